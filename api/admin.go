@@ -0,0 +1,258 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Bnei-Baruch/strdb/utils"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerPatch holds server config fields changed by PATCH /admin/servers/:name,
+// nil fields are left as is
+type ServerPatch struct {
+	DNS         *string  `json:"dns"`
+	MaxSessions *int     `json:"max_sessions"`
+	Weight      *float64 `json:"weight"`
+	Enable      *bool    `json:"enable"`
+	Region      *Regions `json:"region"`
+}
+
+func (p ServerPatch) apply(server *Server) {
+	if p.DNS != nil {
+		server.DNS = *p.DNS
+	}
+	if p.MaxSessions != nil {
+		server.MaxSessions = *p.MaxSessions
+	}
+	if p.Weight != nil {
+		server.Weight = *p.Weight
+	}
+	if p.Enable != nil {
+		server.Enable = *p.Enable
+	}
+	if p.Region != nil {
+		server.Region = *p.Region
+	}
+}
+
+// withRuntime copies fields maintained by strdb itself from the current server record
+func (s Server) withRuntime(current Server) Server {
+	s.Sessions = current.Sessions
+	s.Pending = current.Pending
+	s.Online = current.Online
+	s.Draining = current.Draining
+	s.Drained = current.Drained
+	s.MissedPing = current.MissedPing
+	s.LastSeen = current.LastSeen
+	s.Health = current.Health
+	s.AdminError = current.AdminError
+	s.AdminRTT = current.AdminRTT
+	s.OnlineSince = current.OnlineSince
+	s.Janus = current.Janus
+	return s
+}
+
+// AddServer adds a new server to StrDB, it survives config reloads
+func AddServer(server Server) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if _, ok := StrDB[server.Name]; ok {
+		return fmt.Errorf("server %s already exists", server.Name)
+	}
+	if err := validateWith(server); err != nil {
+		return err
+	}
+	StrDB[server.Name] = server
+	overrideAdded(server)
+	emitServer(server.Name)
+	return nil
+}
+
+// UpdateServer changes the server with fn, runtime fields are preserved and the
+// changed config fields survive config reloads.
+// Returns the server before and after the change.
+func UpdateServer(name string, fn func(*Server)) (Server, Server, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	before, ok := StrDB[name]
+	if !ok {
+		return Server{}, Server{}, ServerNotFound{Name: name}
+	}
+
+	after := before
+	fn(&after)
+	after.Name = name
+	after = after.withRuntime(before)
+	if err := validateWith(after); err != nil {
+		return before, after, err
+	}
+	StrDB[name] = after
+	overrideUpdated(before, after)
+	emitServer(name)
+
+	return before, after, nil
+}
+
+// validateWith validates StrDB with the server added or replaced. Must be called with mutex held.
+func validateWith(server Server) error {
+	conf := Config{}
+	for name, s := range StrDB {
+		conf[name] = s
+	}
+	conf[server.Name] = server
+	return ValidateConfig(conf)
+}
+
+// RemoveServer deletes the server and everything assigned to it,
+// config reloads do not bring it back
+func RemoveServer(name string) (Server, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	server, ok := StrDB[name]
+	if !ok {
+		return Server{}, ServerNotFound{Name: name}
+	}
+
+	clearPending(name)
+	delete(StrDB, name)
+	overrideRemoved(name)
+	clearAssignments(name)
+	clearRoomServer(name)
+	emitServer(name)
+
+	return server, nil
+}
+
+func getAdminServer(c *gin.Context) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	server, ok := StrDB[c.Param("name")]
+	if !ok {
+		NewHttpError(http.StatusNotFound, ServerNotFound{Name: c.Param("name")}, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+	c.JSON(http.StatusOK, server)
+}
+
+func createServer(c *gin.Context) {
+	server, err := bindServer(c)
+	if err != nil {
+		NewBadRequestError(err).Abort(c)
+		return
+	}
+
+	// Sessions are counted by strdb itself
+	server.Pending = 0
+	server.Drained = false
+	if err := AddServer(server); err != nil {
+		NewHttpError(adminErrorStatus(err), err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "create", server.Name, nil, server)
+	c.JSON(http.StatusCreated, server)
+}
+
+func replaceServer(c *gin.Context) {
+	server, err := bindServer(c)
+	if err != nil {
+		NewBadRequestError(err).Abort(c)
+		return
+	}
+
+	before, after, err := UpdateServer(server.Name, func(s *Server) { *s = server })
+	if err != nil {
+		NewHttpError(adminErrorStatus(err), err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "replace", server.Name, before, after)
+	c.JSON(http.StatusOK, after)
+}
+
+func patchServer(c *gin.Context) {
+	var patch ServerPatch
+	if err := c.BindJSON(&patch); err != nil {
+		NewBadRequestError(err).Abort(c)
+		return
+	}
+
+	name := c.Param("name")
+	before, after, err := UpdateServer(name, patch.apply)
+	if err != nil {
+		NewHttpError(adminErrorStatus(err), err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "patch", name, before, after)
+	c.JSON(http.StatusOK, after)
+}
+
+func deleteServer(c *gin.Context) {
+	name := c.Param("name")
+	server, err := RemoveServer(name)
+	if err != nil {
+		NewHttpError(http.StatusNotFound, err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "delete", name, server, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// adminErrorStatus maps server change errors to HTTP status codes
+func adminErrorStatus(err error) int {
+	var notFound ServerNotFound
+	var invalid ConfigErrors
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &invalid):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusConflict
+	}
+}
+
+// bindServer reads server from the body, its name must match the path
+func bindServer(c *gin.Context) (Server, error) {
+	var server Server
+	if err := c.BindJSON(&server); err != nil {
+		return server, err
+	}
+
+	name := c.Param("name")
+	if server.Name == "" {
+		server.Name = name
+	}
+	if server.Name != name {
+		return server, errors.New("server name in body does not match the path")
+	}
+	return server, nil
+}
+
+// audit logs a change made through the admin API
+func audit(c *gin.Context, action string, name string, before interface{}, after interface{}) {
+	fields := log.Fields{
+		"audit":  true,
+		"action": action,
+		"server": name,
+		"ip":     c.ClientIP(),
+		"before": before,
+		"after":  after,
+	}
+	if v, ok := c.Get("USER"); ok {
+		user := v.(*utils.User)
+		fields["user"] = user.Email
+		fields["account_id"] = user.AccountID
+	}
+
+	log.WithFields(fields).Info("[audit] Server changed via admin API")
+}
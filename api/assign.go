@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AssignRequest is the payload of mqtt.assign_topic: the POST /server body plus
+// where to reply. With MQTT v5 the response topic and correlation data properties
+// take precedence, MQTT v3 clients put them in the body. The response topic
+// must be under mqtt.reply_prefix.
+type AssignRequest struct {
+	User
+	ResponseTopic   string `json:"response_topic"`
+	CorrelationData string `json:"correlation_data,omitempty"`
+}
+
+// AssignResponse is published to the response topic of AssignRequest
+type AssignResponse struct {
+	Server          string `json:"server,omitempty"`
+	Pool            string `json:"pool,omitempty"`
+	Status          int    `json:"status"` // Same as the HTTP status of POST /server
+	Error           string `json:"error,omitempty"`
+	CorrelationData string `json:"correlation_data,omitempty"`
+}
+
+// HandleAssignMessage selects a server for the user the same way as POST /server
+func HandleAssignMessage(m *Message) {
+	go handleAssign(m)
+}
+
+// handleAssign answers a request synchronously, HandleAssignMessage runs it off the MQTT client goroutine
+func handleAssign(m *Message) {
+	var req AssignRequest
+	if err := json.Unmarshal(m.Payload, &req); err != nil {
+		log.WithFields(log.Fields{
+			"topic": m.Topic,
+			"error": err.Error(),
+		}).Error("[HandleAssignMessage] Failed to unmarshal")
+		return
+	}
+
+	var props *Properties
+	if m.ResponseTopic != "" {
+		req.ResponseTopic = m.ResponseTopic
+		props = &Properties{CorrelationData: m.CorrelationData}
+	}
+
+	if req.ResponseTopic == "" {
+		log.WithFields(log.Fields{
+			"topic":    m.Topic,
+			"username": req.Username,
+		}).Error("[HandleAssignMessage] Request without response_topic")
+		return
+	}
+	if !replyAllowed(req.ResponseTopic) {
+		log.WithFields(log.Fields{
+			"topic":          m.Topic,
+			"username":       req.Username,
+			"response_topic": req.ResponseTopic,
+			"prefix":         replyPrefix(),
+		}).Error("[HandleAssignMessage] Response topic outside of mqtt.reply_prefix")
+		return
+	}
+
+	publishAssignResponse(req.ResponseTopic, assign(&req.User, req.CorrelationData), props)
+}
+
+// assign runs the selection for an MQTT client. There is no connection to take
+// the address from, and GeoIP of the ip in the body would check the client
+// against its own claim, so GeoIP is skipped: geo.country_code of the client
+// is used even with geoip.override.
+func assign(user *User, correlationData string) AssignResponse {
+	resp := AssignResponse{CorrelationData: correlationData}
+
+	a, err := assignServer(user, "")
+	if err != nil {
+		resp.Status = selectionErrorStatus(err)
+		resp.Error = err.Error()
+		return resp
+	}
+
+	resp.Status = http.StatusOK
+	resp.Server = a.Server
+	resp.Pool = a.Pool
+	return resp
+}
+
+// publishAssignResponse publishes directly instead of through the outbox,
+// which drops messages when full or disconnected: the client waits for it
+func publishAssignResponse(topic string, resp AssignResponse, props *Properties) {
+	message, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorf("[publishAssignResponse] Message parsing: %s", err)
+		return
+	}
+
+	if err := MQTT.Publish(topic, false, message, props); err != nil {
+		log.WithFields(log.Fields{
+			"topic": topic,
+			"error": err.Error(),
+		}).Error("[publishAssignResponse] Publish")
+	}
+}
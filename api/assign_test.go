@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHandleAssignMessage(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	withServers(t, Config{"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true}})
+	broker := withBroker(t)
+
+	request := func(req AssignRequest, props Properties) {
+		payload, _ := json.Marshal(req)
+		handleAssign(&Message{Topic: "strdb/assign", Payload: payload, Properties: props})
+	}
+	response := func(topic string) (AssignResponse, published) {
+		p := broker.next(t, topic)
+		var resp AssignResponse
+		if err := json.Unmarshal(p.Payload, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp, p
+	}
+
+	// MQTT v3, reply topic and correlation in the body
+	request(AssignRequest{User: User{ID: "u1"}, ResponseTopic: "strdb/reply/u1", CorrelationData: "c1"}, Properties{})
+	if resp, _ := response("strdb/reply/u1"); resp.Status != http.StatusOK || resp.Server != "str1" || resp.CorrelationData != "c1" {
+		t.Errorf("v3 response = %+v", resp)
+	}
+
+	// MQTT v5 properties take precedence
+	request(AssignRequest{User: User{ID: "u2"}, ResponseTopic: "strdb/reply/body"}, Properties{ResponseTopic: "strdb/reply/u2", CorrelationData: []byte("c2")})
+	if resp, p := response("strdb/reply/u2"); resp.Status != http.StatusOK || p.Props == nil || string(p.Props.CorrelationData) != "c2" {
+		t.Errorf("v5 response = %+v, properties %+v", resp, p.Props)
+	}
+
+	// Response topics outside of mqtt.reply_prefix get nothing and assign nothing
+	request(AssignRequest{User: User{ID: "u3"}, ResponseTopic: "janus/str1/to-janus-admin"}, Properties{})
+	request(AssignRequest{User: User{ID: "u4"}}, Properties{ResponseTopic: "strdb/reply/#"})
+	broker.none(t, "janus/str1/to-janus-admin")
+	broker.none(t, "strdb/reply/#")
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if n := StrDB["str1"].Pending; n != 2 {
+		t.Errorf("pending = %d, want 2 assignments", n)
+	}
+}
+
+func TestAssignSkipsGeoIP(t *testing.T) {
+	withGeoIP(t, true)
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true, Region: Regions{"RU"}},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true, Online: true, Region: Regions{"DE"}},
+	})
+
+	// The ip in the body is the client's claim like the country, it is not looked up
+	user := &User{ID: "u1", IP: "2.16.1.1", Geo: Geo{CountryCode: "RU"}}
+	if resp := assign(user, ""); resp.Status != http.StatusOK || resp.Server != "str1" {
+		t.Errorf("response = %+v, want str1 for RU", resp)
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc"
+)
+
+const testKeyID = "test-key"
+
+// testIssuer serves a JWKS with one RSA key and signs tokens with it
+type testIssuer struct {
+	URL string
+	key *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &testIssuer{URL: srv.URL, key: key}
+}
+
+func (i *testIssuer) verifier() *oidc.IDTokenVerifier {
+	keySet := oidc.NewRemoteKeySet(context.Background(), i.URL+"/certs")
+	return oidc.NewVerifier(i.URL, keySet, &oidc.Config{SkipClientIDCheck: true})
+}
+
+// token returns an RS256 token of the issuer with the claims added to the standard ones
+func (i *testIssuer) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	now := time.Now()
+	payload := map[string]interface{}{
+		"iss":   i.URL,
+		"sub":   "user-1",
+		"email": "user@example.com",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": testKeyID})
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal claims: %s", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func realmRoles(roles ...string) map[string]interface{} {
+	return map[string]interface{}{"realm_access": map[string]interface{}{"roles": roles}}
+}
+
+func clientRoles(client string, roles ...string) map[string]interface{} {
+	return map[string]interface{}{"resource_access": map[string]interface{}{
+		client: map[string]interface{}{"roles": roles},
+	}}
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": []string{"Bearer " + token}}
+}
+
+func TestRouteAuthorization(t *testing.T) {
+	issuer := newTestIssuer(t)
+	other := newTestIssuer(t)
+
+	withConfig(t, map[string]interface{}{
+		"authentication.enable":    true,
+		"authentication.client_id": "strdb",
+	})
+	withServers(t, Config{"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true}})
+	router := newTestRouter(issuer.verifier())
+
+	operator := issuer.token(t, realmRoles("strdb_operator"))
+	admin := issuer.token(t, realmRoles("strdb_admin"))
+	clientAdmin := issuer.token(t, clientRoles("strdb", "strdb_admin"))
+	otherClientAdmin := issuer.token(t, clientRoles("other", "strdb_admin"))
+	noRoles := issuer.token(t, nil)
+	expired := issuer.token(t, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+	foreign := other.token(t, realmRoles("strdb_admin"))
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		header       http.Header
+		want         int
+	}{
+		{"public without token", http.MethodGet, "/server?country_code=IL", "", nil, http.StatusOK},
+		{"health without token", http.MethodGet, "/healthz", "", nil, http.StatusOK},
+
+		{"operator route without token", http.MethodGet, "/status", "", nil, http.StatusUnauthorized},
+		{"operator route with malformed header", http.MethodGet, "/status", "", http.Header{"Authorization": []string{"Token abc"}}, http.StatusUnauthorized},
+		{"operator route with garbage token", http.MethodGet, "/status", "", bearer("not.a.token"), http.StatusUnauthorized},
+		{"operator route with expired token", http.MethodGet, "/status", "", bearer(expired), http.StatusUnauthorized},
+		{"operator route with token of another issuer", http.MethodGet, "/status", "", bearer(foreign), http.StatusUnauthorized},
+		{"operator route without role", http.MethodGet, "/status", "", bearer(noRoles), http.StatusForbidden},
+		{"operator route as operator", http.MethodGet, "/status", "", bearer(operator), http.StatusOK},
+		{"operator route as admin", http.MethodGet, "/admin/servers/str1", "", bearer(admin), http.StatusOK},
+		{"assignments without token", http.MethodGet, "/admin/assignments", "", nil, http.StatusUnauthorized},
+		{"assignments without role", http.MethodGet, "/admin/assignments", "", bearer(noRoles), http.StatusForbidden},
+		{"assignments as operator", http.MethodGet, "/admin/assignments", "", bearer(operator), http.StatusOK},
+
+		{"admin route without token", http.MethodPatch, "/admin/servers/str1", `{"weight": 2}`, nil, http.StatusUnauthorized},
+		{"admin route as operator", http.MethodPatch, "/admin/servers/str1", `{"weight": 2}`, bearer(operator), http.StatusForbidden},
+		{"admin route without role", http.MethodPatch, "/admin/servers/str1", `{"weight": 2}`, bearer(noRoles), http.StatusForbidden},
+		{"admin route with realm role", http.MethodPatch, "/admin/servers/str1", `{"weight": 2}`, bearer(admin), http.StatusOK},
+		{"admin route with client role", http.MethodPatch, "/admin/servers/str1", `{"weight": 3}`, bearer(clientAdmin), http.StatusOK},
+		{"admin route with role of another client", http.MethodPatch, "/admin/servers/str1", `{"weight": 4}`, bearer(otherClientAdmin), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, tt.method, tt.path, tt.body, tt.header)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if w := StrDB["str1"].Weight; w != 3 {
+		t.Errorf("weight = %v, want 3 (only admin requests applied)", w)
+	}
+}
+
+func TestClientRolesOfAnyClient(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	// Without client_id a role of any client counts
+	withConfig(t, map[string]interface{}{"authentication.enable": true})
+	withServers(t, Config{})
+	router := newTestRouter(issuer.verifier())
+
+	token := issuer.token(t, clientRoles("other", "strdb_operator"))
+	if w := serve(router, http.MethodGet, "/status", "", bearer(token)); w.Code != http.StatusOK {
+		t.Errorf("GET /status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultKeepAlive      = 30 * time.Second
+	defaultConnectTimeout = 30 * time.Second
+	publishTimeout        = 5 * time.Second
+)
+
+// Message is an incoming MQTT message. Properties are only set with MQTT v5.
+type Message struct {
+	Topic   string
+	Payload []byte
+	Properties
+}
+
+// Properties are MQTT v5 message properties, ignored with MQTT v3
+type Properties struct {
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  map[string]string
+}
+
+type MessageHandler func(m *Message)
+
+// Broker is the MQTT connection, implemented for MQTT v3 (paho.mqtt.golang)
+// and MQTT v5 (paho.golang) selected by mqtt.version. All messages use QoS 1.
+type Broker interface {
+	Connect() error
+	Publish(topic string, retained bool, payload []byte, props *Properties) error
+	Subscribe(topic string, handler MessageHandler) error
+	IsConnected() bool
+	Disconnect()
+}
+
+// BrokerOptions are the connection settings shared by both protocol versions
+type BrokerOptions struct {
+	URL            string
+	ClientID       string
+	User           string
+	Password       string
+	TLS            *tls.Config
+	KeepAlive      time.Duration
+	ConnectTimeout time.Duration
+	CleanSession   bool
+	SessionExpiry  time.Duration // MQTT v5 only
+	WillTopic      string
+	WillPayload    []byte
+	OnConnect      func()
+	OnLost         func(err error)
+}
+
+// NewBroker creates the broker client for mqtt.version: 3 (3.1), 4 (3.1.1, default) or 5
+func NewBroker(opts BrokerOptions) (Broker, error) {
+	switch version := viper.GetInt("mqtt.version"); version {
+	case 0, 3, 4:
+		return newBrokerV3(opts, version), nil
+	case 5:
+		// Keep the interface nil on error, not a nil *brokerV5
+		b, err := newBrokerV5(opts)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported mqtt.version: %d", version)
+	}
+}
+
+// brokerOptions reads connection settings from mqtt.*
+func brokerOptions() (BrokerOptions, error) {
+	opts := BrokerOptions{
+		URL:            viper.GetString("mqtt.url"),
+		ClientID:       viper.GetString("mqtt.client_id"),
+		User:           viper.GetString("mqtt.user"),
+		Password:       viper.GetString("mqtt.password"),
+		KeepAlive:      defaultKeepAlive,
+		ConnectTimeout: defaultConnectTimeout,
+		CleanSession:   true,
+		SessionExpiry:  viper.GetDuration("mqtt.session_expiry"),
+		WillTopic:      viper.GetString("mqtt.status_topic"),
+		WillPayload:    []byte("Offline"),
+		OnConnect:      SubMQTT,
+		OnLost:         LostMQTT,
+	}
+	if k := viper.GetDuration("mqtt.keepalive"); k > 0 {
+		opts.KeepAlive = k
+	}
+	if t := viper.GetDuration("mqtt.connect_timeout"); t > 0 {
+		opts.ConnectTimeout = t
+	}
+	if viper.IsSet("mqtt.clean_session") {
+		opts.CleanSession = viper.GetBool("mqtt.clean_session")
+	}
+
+	tlsConfig, err := mqttTLSConfig()
+	if err != nil {
+		return opts, err
+	}
+	opts.TLS = tlsConfig
+
+	return opts, nil
+}
+
+// mqttTLSConfig builds TLS settings from mqtt.tls.*, nil when none are set
+// and the system defaults apply to ssl:// and tls:// URLs
+func mqttTLSConfig() (*tls.Config, error) {
+	if !viper.IsSet("mqtt.tls") {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         viper.GetString("mqtt.tls.server_name"),
+		InsecureSkipVerify: viper.GetBool("mqtt.tls.insecure_skip_verify"),
+	}
+
+	if ca := viper.GetString("mqtt.tls.ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt.tls.ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt.tls.ca: no certificates in %s", ca)
+		}
+		cfg.RootCAs = pool
+	}
+
+	cert, key := viper.GetString("mqtt.tls.cert"), viper.GetString("mqtt.tls.key")
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("mqtt.tls client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate and its key as PEM files
+func writeCert(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMqttTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := writeCert(t, dir, "ca")
+	certFile, keyFile := writeCert(t, dir, "client")
+	_, otherKey := writeCert(t, dir, "other")
+	badPEM := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(badPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		cfg, err := mqttTLSConfig()
+		if cfg != nil || err != nil {
+			t.Errorf("got %v, %v, want nil, nil", cfg, err)
+		}
+	})
+
+	t.Run("server name and verification", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{
+			"mqtt.tls.server_name":          "broker.example.com",
+			"mqtt.tls.insecure_skip_verify": true,
+		})
+		cfg, err := mqttTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ServerName != "broker.example.com" || !cfg.InsecureSkipVerify || cfg.RootCAs != nil || len(cfg.Certificates) != 0 {
+			t.Errorf("unexpected config %+v", cfg)
+		}
+	})
+
+	t.Run("ca", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{"mqtt.tls.ca": caFile})
+		cfg, err := mqttTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.RootCAs == nil {
+			t.Fatal("RootCAs not set")
+		}
+
+		pem, _ := os.ReadFile(caFile)
+		want := x509.NewCertPool()
+		want.AppendCertsFromPEM(pem)
+		if !cfg.RootCAs.Equal(want) {
+			t.Error("RootCAs do not hold the CA")
+		}
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{"mqtt.tls.cert": certFile, "mqtt.tls.key": keyFile})
+		cfg, err := mqttTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cfg.Certificates) != 1 {
+			t.Errorf("certificates = %d, want 1", len(cfg.Certificates))
+		}
+	})
+
+	errors := []struct {
+		name     string
+		settings map[string]interface{}
+	}{
+		{"bad ca pem", map[string]interface{}{"mqtt.tls.ca": badPEM}},
+		{"missing ca", map[string]interface{}{"mqtt.tls.ca": filepath.Join(dir, "missing.pem")}},
+		{"cert without key", map[string]interface{}{"mqtt.tls.cert": certFile}},
+		{"key without cert", map[string]interface{}{"mqtt.tls.key": keyFile}},
+		{"key of another cert", map[string]interface{}{"mqtt.tls.cert": certFile, "mqtt.tls.key": otherKey}},
+		{"bad cert pem", map[string]interface{}{"mqtt.tls.cert": badPEM, "mqtt.tls.key": keyFile}},
+	}
+	for _, tt := range errors {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.settings)
+			if cfg, err := mqttTLSConfig(); err == nil {
+				t.Errorf("got %+v, want error", cfg)
+			}
+		})
+	}
+}
+
+func TestNewBroker(t *testing.T) {
+	tests := []struct {
+		version interface{}
+		url     string
+		want    string
+	}{
+		{nil, "tcp://localhost:1883", "v3"},
+		{3, "tcp://localhost:1883", "v3"},
+		{4, "tcp://localhost:1883", "v3"},
+		{5, "tcp://localhost:1883", "v5"},
+		{5, "://bad url", "error"},
+		{2, "tcp://localhost:1883", "error"},
+		{6, "tcp://localhost:1883", "error"},
+	}
+
+	for _, tt := range tests {
+		settings := map[string]interface{}{}
+		if tt.version != nil {
+			settings["mqtt.version"] = tt.version
+		}
+		withConfig(t, settings)
+
+		b, err := NewBroker(BrokerOptions{URL: tt.url, OnConnect: func() {}, OnLost: func(error) {}})
+		got := "error"
+		switch b.(type) {
+		case *brokerV3:
+			got = "v3"
+		case *brokerV5:
+			got = "v5"
+		}
+		if got != tt.want || (err != nil) != (tt.want == "error") {
+			t.Errorf("version %v, url %q: got %s (err %v), want %s", tt.version, tt.url, got, err, tt.want)
+		}
+	}
+}
+
+func TestBrokerOptions(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	opts, err := brokerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.KeepAlive != defaultKeepAlive || opts.ConnectTimeout != defaultConnectTimeout || !opts.CleanSession || opts.TLS != nil {
+		t.Errorf("defaults = %+v", opts)
+	}
+
+	withConfig(t, map[string]interface{}{
+		"mqtt.keepalive":       "10s",
+		"mqtt.connect_timeout": "3s",
+		"mqtt.clean_session":   false,
+		"mqtt.session_expiry":  "1h",
+		"mqtt.tls.ca":          filepath.Join(t.TempDir(), "missing.pem"),
+	})
+	if _, err := brokerOptions(); err == nil {
+		t.Error("TLS error not returned")
+	}
+
+	withConfig(t, map[string]interface{}{
+		"mqtt.keepalive":       "10s",
+		"mqtt.connect_timeout": "3s",
+		"mqtt.clean_session":   false,
+		"mqtt.session_expiry":  "1h",
+	})
+	opts, err = brokerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.KeepAlive != 10*time.Second || opts.ConnectTimeout != 3*time.Second || opts.CleanSession || opts.SessionExpiry != time.Hour {
+		t.Errorf("options = %+v", opts)
+	}
+}
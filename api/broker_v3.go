@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// brokerV3 is the MQTT 3.1/3.1.1 client
+type brokerV3 struct {
+	client mqtt.Client
+}
+
+func newBrokerV3(opts BrokerOptions, version int) *brokerV3 {
+	if viper.GetString("mqtt.debug") == "true" {
+		mqtt.DEBUG = NewPahoLogAdapter(log.DebugLevel)
+		mqtt.WARN = NewPahoLogAdapter(log.WarnLevel)
+	}
+	mqtt.CRITICAL = NewPahoLogAdapter(log.PanicLevel)
+	mqtt.ERROR = NewPahoLogAdapter(log.ErrorLevel)
+
+	o := mqtt.NewClientOptions()
+	o.AddBroker(opts.URL)
+	o.SetClientID(opts.ClientID)
+	o.SetUsername(opts.User)
+	o.SetPassword(opts.Password)
+	if opts.TLS != nil {
+		o.SetTLSConfig(opts.TLS)
+	}
+	if version != 0 {
+		o.SetProtocolVersion(uint(version))
+	}
+	o.SetKeepAlive(opts.KeepAlive)
+	o.SetConnectTimeout(opts.ConnectTimeout)
+	o.SetCleanSession(opts.CleanSession)
+	o.SetAutoReconnect(true)
+	o.SetOnConnectHandler(func(c mqtt.Client) { opts.OnConnect() })
+	o.SetConnectionLostHandler(func(c mqtt.Client, err error) { opts.OnLost(err) })
+	o.SetBinaryWill(opts.WillTopic, opts.WillPayload, byte(1), true)
+
+	if opts.SessionExpiry > 0 {
+		log.Warn("[newBrokerV3] mqtt.session_expiry requires mqtt.version 5, ignored")
+	}
+
+	return &brokerV3{client: mqtt.NewClient(o)}
+}
+
+func (b *brokerV3) Connect() error {
+	token := b.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+func (b *brokerV3) Publish(topic string, retained bool, payload []byte, props *Properties) error {
+	token := b.client.Publish(topic, byte(1), retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return errors.New("publish timeout")
+	}
+	return token.Error()
+}
+
+func (b *brokerV3) Subscribe(topic string, handler MessageHandler) error {
+	token := b.client.Subscribe(topic, byte(1), func(c mqtt.Client, m mqtt.Message) {
+		handler(&Message{Topic: m.Topic(), Payload: m.Payload()})
+	})
+	token.Wait()
+	return token.Error()
+}
+
+func (b *brokerV3) IsConnected() bool {
+	return b.client.IsConnectionOpen()
+}
+
+func (b *brokerV3) Disconnect() {
+	b.client.Disconnect(1000)
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// brokerV5 is the MQTT 5 client, reconnects by itself like the v3 client
+type brokerV5 struct {
+	config    autopaho.ClientConfig
+	router    *paho.StandardRouter
+	connected atomic.Bool
+
+	mu     sync.Mutex
+	cm     *autopaho.ConnectionManager
+	cancel context.CancelFunc
+}
+
+func newBrokerV5(opts BrokerOptions) (*brokerV5, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &brokerV5{router: paho.NewStandardRouter()}
+	b.config = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        opts.TLS,
+		KeepAlive:                     uint16(opts.KeepAlive.Seconds()),
+		ConnectTimeout:                opts.ConnectTimeout,
+		CleanStartOnInitialConnection: opts.CleanSession,
+		SessionExpiryInterval:         uint32(opts.SessionExpiry.Seconds()),
+		ConnectUsername:               opts.User,
+		ConnectPassword:               []byte(opts.Password),
+		WillMessage: &paho.WillMessage{
+			Retain:  true,
+			QoS:     1,
+			Topic:   opts.WillTopic,
+			Payload: opts.WillPayload,
+		},
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, connAck *paho.Connack) {
+			// May run before NewConnection returns in Connect
+			b.mu.Lock()
+			b.cm = cm
+			b.mu.Unlock()
+			b.connected.Store(true)
+			opts.OnConnect()
+		},
+		OnConnectError: func(err error) {
+			log.Errorf("[brokerV5] Connect error: %s", err)
+		},
+		Errors: NewPahoLogAdapter(log.ErrorLevel),
+		ClientConfig: paho.ClientConfig{
+			ClientID: opts.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					b.router.Route(pr.Packet.Packet())
+					return true, nil
+				},
+			},
+			OnClientError: func(err error) {
+				b.connected.Store(false)
+				opts.OnLost(err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				b.connected.Store(false)
+				opts.OnLost(errors.New("disconnected by server"))
+			},
+		},
+	}
+	if viper.GetString("mqtt.debug") == "true" {
+		b.config.Debug = NewPahoLogAdapter(log.DebugLevel)
+		b.config.PahoDebug = NewPahoLogAdapter(log.DebugLevel)
+	}
+
+	return b, nil
+}
+
+func (b *brokerV5) Connect() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, b.config)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	b.mu.Lock()
+	b.cm = cm
+	b.cancel = cancel
+	b.mu.Unlock()
+
+	// Same as v3: the first connection must succeed, later ones are retried
+	wait, stop := context.WithTimeout(ctx, b.config.ConnectTimeout)
+	defer stop()
+	if err := cm.AwaitConnection(wait); err != nil {
+		cancel()
+		return err
+	}
+	return nil
+}
+
+func (b *brokerV5) manager() (*autopaho.ConnectionManager, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cm == nil {
+		return nil, errors.New("not connected")
+	}
+	return b.cm, nil
+}
+
+func (b *brokerV5) Publish(topic string, retained bool, payload []byte, props *Properties) error {
+	cm, err := b.manager()
+	if err != nil {
+		return err
+	}
+
+	p := &paho.Publish{
+		QoS:     1,
+		Topic:   topic,
+		Retain:  retained,
+		Payload: payload,
+	}
+	if props != nil {
+		p.Properties = &paho.PublishProperties{
+			ResponseTopic:   props.ResponseTopic,
+			CorrelationData: props.CorrelationData,
+		}
+		for k, v := range props.UserProperties {
+			p.Properties.User.Add(k, v)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	_, err = cm.Publish(ctx, p)
+	return err
+}
+
+func (b *brokerV5) Subscribe(topic string, handler MessageHandler) error {
+	cm, err := b.manager()
+	if err != nil {
+		return err
+	}
+
+	// SubMQTT runs on every reconnect, replace the handler instead of adding another
+	b.router.UnregisterHandler(topic)
+	b.router.RegisterHandler(topic, func(p *paho.Publish) {
+		m := &Message{Topic: p.Topic, Payload: p.Payload}
+		if p.Properties != nil {
+			m.ResponseTopic = p.Properties.ResponseTopic
+			m.CorrelationData = p.Properties.CorrelationData
+			if len(p.Properties.User) > 0 {
+				m.UserProperties = make(map[string]string, len(p.Properties.User))
+				for _, u := range p.Properties.User {
+					m.UserProperties[u.Key] = u.Value
+				}
+			}
+		}
+		handler(m)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.ConnectTimeout)
+	defer cancel()
+	_, err = cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: 1}},
+	})
+	return err
+}
+
+func (b *brokerV5) IsConnected() bool {
+	return b.connected.Load()
+}
+
+func (b *brokerV5) Disconnect() {
+	cm, err := b.manager()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := cm.Disconnect(ctx); err != nil {
+		log.Errorf("[brokerV5] Disconnect: %s", err)
+	}
+	b.connected.Store(false)
+
+	b.mu.Lock()
+	b.cancel()
+	b.mu.Unlock()
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Bnei-Baruch/strdb/utils"
+	"github.com/coreos/go-oidc"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Commands accepted on mqtt.cmd_topic
+const (
+	CmdEnable   = "enable"
+	CmdDisable  = "disable"
+	CmdDrain    = "drain"
+	CmdReload   = "reload"
+	CmdCapacity = "capacity"
+	CmdDump     = "dump"
+)
+
+const (
+	defaultCmdReplyTopic = "strdb/cmd/reply"
+
+	// defaultReplyPrefix is where clients may ask replies to be published,
+	// see mqtt.reply_prefix
+	defaultReplyPrefix = "strdb/reply/"
+)
+
+// TokenVerifier verifies tokens of MQTT commands, set on init when authentication.enable is set
+var TokenVerifier *oidc.IDTokenVerifier
+
+// CapacityRequest is the Data of the capacity command, nil fields are left as is
+type CapacityRequest struct {
+	MaxSessions *int     `json:"max_sessions"`
+	Weight      *float64 `json:"weight"`
+}
+
+// HandleCommandMessage executes a command from mqtt.cmd_topic and publishes the
+// reply with the same ID to ReplyTo, or to <mqtt.cmd_reply_topic>/<ID> when not set.
+// ReplyTo must be under mqtt.reply_prefix, otherwise the command is dropped.
+func HandleCommandMessage(m *Message) {
+	go handleCommand(m)
+}
+
+// handleCommand runs a command synchronously, HandleCommandMessage runs it off the MQTT client goroutine
+func handleCommand(m *Message) {
+	var cmd MqttPayload
+	if err := json.Unmarshal(m.Payload, &cmd); err != nil {
+		log.WithFields(log.Fields{
+			"topic": m.Topic,
+			"error": err.Error(),
+		}).Error("[HandleCommandMessage] Failed to unmarshal")
+		return
+	}
+
+	// MQTT v5 response topic property takes precedence over reply_to
+	var props *Properties
+	if m.ResponseTopic != "" {
+		cmd.ReplyTo = m.ResponseTopic
+		props = &Properties{CorrelationData: m.CorrelationData}
+	}
+	if cmd.ReplyTo != "" && !replyAllowed(cmd.ReplyTo) {
+		log.WithFields(log.Fields{
+			"audit":    true,
+			"action":   cmd.Action,
+			"server":   cmd.Name,
+			"id":       cmd.ID,
+			"src":      cmd.Source,
+			"reply_to": cmd.ReplyTo,
+			"prefix":   replyPrefix(),
+		}).Error("[HandleCommandMessage] Reply topic outside of mqtt.reply_prefix, command dropped")
+		return
+	}
+
+	reply := MqttPayload{
+		Action: cmd.Action,
+		ID:     cmd.ID,
+		Name:   cmd.Name,
+		Source: "strdb",
+	}
+
+	user, err := authorizeCommand(cmd.Token)
+	var data interface{}
+	if err == nil {
+		data, err = runCommand(cmd)
+	}
+	if err != nil {
+		reply.Result = "error"
+		reply.Error = err.Error()
+	} else {
+		reply.Result = "success"
+		reply.Data = data
+	}
+
+	log.WithFields(log.Fields{
+		"audit":  true,
+		"action": cmd.Action,
+		"server": cmd.Name,
+		"id":     cmd.ID,
+		"src":    cmd.Source,
+		"user":   user,
+		"result": reply.Result,
+		"error":  reply.Error,
+	}).Info("[audit] Command received via MQTT")
+
+	publishReply(replyTopic(cmd), reply, props)
+}
+
+// commandAuthConfigured reports whether commands can be authorized at all
+func commandAuthConfigured() bool {
+	return viper.GetBool("authentication.enable") || viper.GetString("mqtt.cmd_secret") != ""
+}
+
+// authorizeCommand checks the command token. With authentication.enable it must be
+// a valid token with the admin role, otherwise it must match mqtt.cmd_secret.
+// Without both every command is refused. Returns the user for audit.
+func authorizeCommand(token string) (string, error) {
+	if viper.GetBool("authentication.enable") {
+		_, claims, err := utils.VerifyToken(TokenVerifier, token)
+		if err != nil {
+			return "", fmt.Errorf("unauthorized: %w", err)
+		}
+		adminRole := viper.GetString("authentication.admin_role")
+		if !utils.HasAnyRole(claims, viper.GetString("authentication.client_id"), adminRole) {
+			return claims.Email, fmt.Errorf("forbidden: role %s required", adminRole)
+		}
+		return claims.Email, nil
+	}
+
+	secret := viper.GetString("mqtt.cmd_secret")
+	if secret == "" {
+		return "", errors.New("unauthorized: set authentication.enable or mqtt.cmd_secret to allow commands")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return "", errors.New("unauthorized: invalid secret")
+	}
+
+	return "", nil
+}
+
+func runCommand(cmd MqttPayload) (interface{}, error) {
+	switch cmd.Action {
+	case CmdEnable, CmdDisable:
+		enable := cmd.Action == CmdEnable
+		_, after, err := UpdateServer(cmd.Name, func(s *Server) { s.Enable = enable })
+		return after, err
+
+	case CmdDrain:
+		req := DrainRequest{Draining: true}
+		if cmd.Data != nil {
+			if err := decodeData(cmd.Data, &req); err != nil {
+				return nil, err
+			}
+		}
+		if err := SetDraining(cmd.Name, req.Draining); err != nil {
+			return nil, err
+		}
+		return serverSnapshot(cmd.Name), nil
+
+	case CmdReload:
+		return ReloadConf()
+
+	case CmdCapacity:
+		var req CapacityRequest
+		if err := decodeData(cmd.Data, &req); err != nil {
+			return nil, err
+		}
+		patch := ServerPatch{MaxSessions: req.MaxSessions, Weight: req.Weight}
+		_, after, err := UpdateServer(cmd.Name, patch.apply)
+		return after, err
+
+	case CmdDump:
+		if cmd.Name != "" {
+			server := serverSnapshot(cmd.Name)
+			if server == nil {
+				return nil, ServerNotFound{Name: cmd.Name}
+			}
+			return server, nil
+		}
+		mutex.RLock()
+		defer mutex.RUnlock()
+		servers := make(Config, len(StrDB))
+		for name, server := range StrDB {
+			servers[name] = server
+		}
+		return servers, nil
+
+	default:
+		return nil, fmt.Errorf("unknown action: %q", cmd.Action)
+	}
+}
+
+func serverSnapshot(name string) *Server {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	server, ok := StrDB[name]
+	if !ok {
+		return nil
+	}
+	return &server
+}
+
+// decodeData converts the free-form Data of a payload into v
+func decodeData(data interface{}, v interface{}) error {
+	if data == nil {
+		return errors.New("data is required")
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid data: %w", err)
+	}
+	return nil
+}
+
+func replyTopic(cmd MqttPayload) string {
+	if cmd.ReplyTo != "" {
+		return cmd.ReplyTo
+	}
+
+	topic := defaultCmdReplyTopic
+	if t := viper.GetString("mqtt.cmd_reply_topic"); t != "" {
+		topic = strings.TrimSuffix(t, "/")
+	}
+	if cmd.ID != "" {
+		topic += "/" + cmd.ID
+	}
+	return topic
+}
+
+// replyPrefix returns mqtt.reply_prefix with a trailing slash
+func replyPrefix() string {
+	prefix := defaultReplyPrefix
+	if viper.IsSet("mqtt.reply_prefix") {
+		prefix = viper.GetString("mqtt.reply_prefix")
+	}
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// replyAllowed reports whether a topic chosen by a client may be published to.
+// Clients pick reply topics, so they are kept under mqtt.reply_prefix and away
+// from the status, admin and event topics others rely on.
+func replyAllowed(topic string) bool {
+	if strings.ContainsAny(topic, "+#") {
+		return false
+	}
+	prefix := replyPrefix()
+	return strings.HasPrefix(topic, prefix) && len(topic) > len(prefix)
+}
+
+func publishReply(topic string, reply MqttPayload, props *Properties) {
+	message, err := json.Marshal(reply)
+	if err != nil {
+		log.Errorf("[publishReply] Message parsing: %s", err)
+		return
+	}
+
+	if err := MQTT.Publish(topic, false, message, props); err != nil {
+		log.Errorf("[publishReply] Publish: %s", err)
+	}
+}
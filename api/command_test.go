@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReplyAllowed(t *testing.T) {
+	tests := []struct {
+		prefix interface{}
+		topic  string
+		want   bool
+	}{
+		{nil, "strdb/reply/client-1", true},
+		{nil, "strdb/reply/", false},
+		{nil, "strdb/status/str1", false},
+		{nil, "strdb/replyx/client-1", false},
+		{nil, "strdb/reply/+", false},
+		{nil, "strdb/reply/#", false},
+		{"clients/", "clients/abc/reply", true},
+		{"clients", "clients/abc", true},
+		{"clients", "strdb/reply/client-1", false},
+	}
+
+	for _, tt := range tests {
+		settings := map[string]interface{}{}
+		if tt.prefix != nil {
+			settings["mqtt.reply_prefix"] = tt.prefix
+		}
+		withConfig(t, settings)
+
+		if got := replyAllowed(tt.topic); got != tt.want {
+			t.Errorf("replyAllowed(%q) with prefix %v = %v, want %v", tt.topic, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizeCommand(t *testing.T) {
+	t.Run("nothing configured", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		if commandAuthConfigured() {
+			t.Error("commandAuthConfigured() = true without auth and secret")
+		}
+		if _, err := authorizeCommand(""); err == nil {
+			t.Error("command allowed without auth and secret")
+		}
+	})
+
+	t.Run("secret", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{"mqtt.cmd_secret": "s3cret"})
+		if _, err := authorizeCommand("s3cret"); err != nil {
+			t.Errorf("valid secret: %s", err)
+		}
+		for _, token := range []string{"", "wrong", "s3cret2"} {
+			if _, err := authorizeCommand(token); err == nil {
+				t.Errorf("secret %q allowed", token)
+			}
+		}
+	})
+
+	t.Run("token", func(t *testing.T) {
+		issuer := newTestIssuer(t)
+		withConfig(t, map[string]interface{}{
+			"authentication.enable":     true,
+			"authentication.admin_role": "strdb_admin",
+			"mqtt.cmd_secret":           "s3cret",
+		})
+		prev := TokenVerifier
+		TokenVerifier = issuer.verifier()
+		t.Cleanup(func() { TokenVerifier = prev })
+
+		if user, err := authorizeCommand(issuer.token(t, realmRoles("strdb_admin"))); err != nil || user != "user@example.com" {
+			t.Errorf("admin token: user %q, err %v", user, err)
+		}
+		if _, err := authorizeCommand(issuer.token(t, realmRoles("strdb_operator"))); err == nil || !strings.HasPrefix(err.Error(), "forbidden") {
+			t.Errorf("operator token: err %v, want forbidden", err)
+		}
+		// With authentication the secret is not accepted
+		if _, err := authorizeCommand("s3cret"); err == nil {
+			t.Error("secret allowed with authentication enabled")
+		}
+	})
+}
+
+func TestHandleCommandMessage(t *testing.T) {
+	withConfig(t, map[string]interface{}{"mqtt.cmd_secret": "s3cret"})
+	withServers(t, Config{"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true}})
+	broker := withBroker(t)
+
+	command := func(cmd MqttPayload, props Properties) {
+		payload, _ := json.Marshal(cmd)
+		handleCommand(&Message{Topic: "strdb/cmd", Payload: payload, Properties: props})
+	}
+	reply := func(topic string) MqttPayload {
+		var r MqttPayload
+		if err := json.Unmarshal(broker.next(t, topic).Payload, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	command(MqttPayload{Action: CmdDisable, Name: "str1", ID: "1", Token: "wrong"}, Properties{})
+	if r := reply("strdb/cmd/reply/1"); r.Result != "error" || !strings.HasPrefix(r.Error, "unauthorized") {
+		t.Errorf("wrong secret reply = %+v", r)
+	}
+
+	command(MqttPayload{Action: CmdDisable, Name: "str1", ID: "2", Token: "s3cret", ReplyTo: "strdb/reply/me"}, Properties{})
+	if r := reply("strdb/reply/me"); r.Result != "success" || r.ID != "2" {
+		t.Errorf("disable reply = %+v", r)
+	}
+
+	// Reply topics outside of the prefix are refused, the command does not run
+	command(MqttPayload{Action: CmdEnable, Name: "str1", ID: "3", Token: "s3cret", ReplyTo: "strdb/status/str1"}, Properties{})
+	command(MqttPayload{Action: CmdEnable, Name: "str1", ID: "4", Token: "s3cret"}, Properties{ResponseTopic: "strdb/events/drained"})
+	broker.none(t, "strdb/status/str1")
+	broker.none(t, "strdb/events/drained")
+
+	mutex.RLock()
+	enabled := StrDB["str1"].Enable
+	mutex.RUnlock()
+	if enabled {
+		t.Error("command with a refused reply topic was executed")
+	}
+
+	// MQTT v5 response topic and correlation data
+	command(MqttPayload{Action: CmdEnable, Name: "str1", ID: "5", Token: "s3cret"}, Properties{ResponseTopic: "strdb/reply/v5", CorrelationData: []byte("c1")})
+	p := broker.next(t, "strdb/reply/v5")
+	if p.Props == nil || string(p.Props.CorrelationData) != "c1" {
+		t.Errorf("v5 reply properties = %+v", p.Props)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DrainRequest is the body of the drain endpoint and the Data of the drain command
+type DrainRequest struct {
+	Draining bool `json:"draining"`
+}
+
+// SetDraining switches drain mode of the server. A draining server gets no new
+// assignments but stays polled until its sessions reach zero.
+func SetDraining(name string, draining bool) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	server, ok := StrDB[name]
+	if !ok {
+		return ServerNotFound{Name: name}
+	}
+
+	if server.Draining != draining {
+		log.WithFields(log.Fields{
+			"server":   name,
+			"draining": draining,
+			"sessions": server.Sessions,
+		}).Info("[SetDraining] Server drain mode changed")
+	}
+
+	server.Draining = draining
+	server.Drained = false
+	StrDB[name] = server
+
+	// Nothing to wait for on a server which is not polled
+	if draining && (!server.Online || !server.Enable) {
+		markDrained(name)
+	}
+	emitServer(name)
+
+	return nil
+}
+
+// checkDrained emits the drained event once a draining server has no sessions.
+// Must be called with mutex held.
+func checkDrained(name string) {
+	server, ok := StrDB[name]
+	if !ok || !server.Draining || server.Drained || server.Sessions > 0 {
+		return
+	}
+	markDrained(name)
+}
+
+// markDrained must be called with mutex held
+func markDrained(name string) {
+	server := StrDB[name]
+	server.Drained = true
+	StrDB[name] = server
+	emitServer(name)
+
+	log.WithFields(log.Fields{
+		"server": name,
+	}).Warn("[markDrained] Server drained, safe to restart")
+
+	publishDrained(name)
+}
+
+func setDraining(c *gin.Context) {
+	var req DrainRequest
+	if err := c.BindJSON(&req); err != nil {
+		NewBadRequestError(err).Abort(c)
+		return
+	}
+
+	name := c.Param("name")
+	if err := SetDraining(name, req.Draining); err != nil {
+		NewHttpError(http.StatusNotFound, err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	audit(c, "drain", name, nil, req)
+	c.JSON(http.StatusOK, StrDB[name])
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDrainedEvent(t *testing.T) {
+	withConfig(t, map[string]interface{}{"mqtt.events_topic": "strdb/events/"})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true, Sessions: 2},
+	})
+	broker := withBroker(t)
+
+	if err := SetDraining("str1", true); err != nil {
+		t.Fatal(err)
+	}
+	broker.none(t, "strdb/events/drained")
+
+	// The last session is gone
+	mutex.Lock()
+	server := StrDB["str1"]
+	server.Sessions = 0
+	StrDB["str1"] = server
+	checkDrained("str1")
+	mutex.Unlock()
+
+	p := broker.next(t, "strdb/events/drained")
+	var event DrainedEvent
+	if err := json.Unmarshal(p.Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != EventDrained || event.Server != "str1" || event.Time == 0 || p.Retained {
+		t.Errorf("drained event = %+v, retained %v", event, p.Retained)
+	}
+}
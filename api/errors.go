@@ -64,3 +64,11 @@ type CollectionNotFound struct {
 func (x CollectionNotFound) Error() string {
 	return fmt.Sprintf("Collection not found, CaptureID = %s", x.CaptureID)
 }
+
+type ServerNotFound struct {
+	Name string
+}
+
+func (x ServerNotFound) Error() string {
+	return fmt.Sprintf("Server not found, name = %s", x.Name)
+}
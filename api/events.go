@@ -0,0 +1,103 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	EventSnapshot      = "snapshot"
+	EventServerChanged = "server_changed"
+	EventServerRemoved = "server_removed"
+)
+
+// StatusEvent is pushed to status stream subscribers: a snapshot of StrDB
+// first, then an event for every server change
+type StatusEvent struct {
+	Type    string  `json:"type"`
+	Name    string  `json:"name,omitempty"`
+	Server  *Server `json:"server,omitempty"`
+	Servers Config  `json:"servers,omitempty"`
+	Time    int64   `json:"time"`
+}
+
+const subscriberBuffer = 64
+
+var (
+	subscribers     = map[chan StatusEvent]struct{}{}
+	subscriberMutex sync.Mutex
+)
+
+// subscribe returns a channel with a StrDB snapshot followed by server change events.
+// The channel is closed when the subscriber is too slow, it should reconnect.
+func subscribe() chan StatusEvent {
+	ch := make(chan StatusEvent, subscriberBuffer)
+
+	// Holding mutex while subscribing makes sure no change is lost between the snapshot and events
+	mutex.RLock()
+	snapshot := Config{}
+	for name, server := range StrDB {
+		snapshot[name] = server
+	}
+	ch <- StatusEvent{Type: EventSnapshot, Servers: snapshot, Time: time.Now().Unix()}
+
+	subscriberMutex.Lock()
+	subscribers[ch] = struct{}{}
+	subscriberMutex.Unlock()
+	mutex.RUnlock()
+
+	return ch
+}
+
+func unsubscribe(ch chan StatusEvent) {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+
+	if _, ok := subscribers[ch]; ok {
+		delete(subscribers, ch)
+		close(ch)
+	}
+}
+
+// broadcast never blocks, so MQTT handlers are not slowed by stream consumers.
+// A subscriber with a full buffer is dropped.
+func broadcast(event StatusEvent) {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Warn("[broadcast] Status stream subscriber is too slow, dropping")
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// emitServer pushes the current state of the server to status streams and
+// its retained MQTT topic. Must be called with mutex held.
+func emitServer(name string) {
+	publishServerState(name)
+
+	server, ok := StrDB[name]
+	if !ok {
+		broadcast(StatusEvent{Type: EventServerRemoved, Name: name, Time: time.Now().Unix()})
+		return
+	}
+	broadcast(StatusEvent{Type: EventServerChanged, Name: name, Server: &server, Time: time.Now().Unix()})
+}
+
+// CloseStreams disconnects all status stream subscribers, used on shutdown
+func CloseStreams() {
+	subscriberMutex.Lock()
+	defer subscriberMutex.Unlock()
+
+	for ch := range subscribers {
+		delete(subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	geoDB    *maxminddb.Reader
+	geoMutex sync.RWMutex
+)
+
+// geoRecord is the subset of MaxMind GeoIP2/GeoLite2 and DB-IP country databases we use
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// InitGeoIP opens the mmdb file configured in geoip.db.
+// GeoIP lookup is disabled when no file is configured.
+func InitGeoIP() error {
+	path := viper.GetString("geoip.db")
+	if path == "" {
+		log.Info("[InitGeoIP] No geoip.db configured, GeoIP lookup disabled")
+		return nil
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+
+	geoMutex.Lock()
+	if geoDB != nil {
+		geoDB.Close()
+	}
+	geoDB = db
+	geoMutex.Unlock()
+
+	log.WithFields(log.Fields{
+		"file":     path,
+		"type":     db.Metadata.DatabaseType,
+		"build_at": db.Metadata.BuildEpoch,
+	}).Info("[InitGeoIP] GeoIP database loaded")
+	return nil
+}
+
+// lookupCountry returns ISO country code for the IP address or empty string
+func lookupCountry(ip string) string {
+	geoMutex.RLock()
+	defer geoMutex.RUnlock()
+
+	if geoDB == nil {
+		return ""
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	var record geoRecord
+	if err := geoDB.Lookup(addr, &record); err != nil {
+		log.WithFields(log.Fields{
+			"ip":    ip,
+			"error": err.Error(),
+		}).Warn("[lookupCountry] GeoIP lookup failed")
+		return ""
+	}
+
+	return strings.ToUpper(record.Country.ISOCode)
+}
+
+// resolveCountry decides which country code to route the client by.
+// The code from the request body is used unless it is empty or
+// geoip.override is set, in which case the GeoIP result wins.
+// Returns the code and its source: "request", "geoip" or "" when unknown.
+func resolveCountry(clientIP string, requested string) (string, string) {
+	requested = strings.ToUpper(requested)
+	if requested != "" && !viper.GetBool("geoip.override") {
+		return requested, "request"
+	}
+
+	detected := lookupCountry(clientIP)
+	if detected == "" {
+		if requested != "" {
+			return requested, "request"
+		}
+		return "", ""
+	}
+
+	if requested != "" && requested != detected {
+		log.WithFields(log.Fields{
+			"ip":        clientIP,
+			"requested": requested,
+			"detected":  detected,
+		}).Warn("[resolveCountry] Country code in request does not match GeoIP, using GeoIP")
+	}
+
+	return detected, "geoip"
+}
@@ -0,0 +1,75 @@
+package api
+
+import "testing"
+
+// testdata/country-test.mmdb maps 5.45.192.0/18 to RU, 2.16.0.0/16 to DE and 1.0.16.0/20 to JP
+func withGeoIP(t *testing.T, override bool) {
+	t.Helper()
+	withConfig(t, map[string]interface{}{
+		"geoip.db":       "testdata/country-test.mmdb",
+		"geoip.override": override,
+	})
+	if err := InitGeoIP(); err != nil {
+		t.Fatalf("InitGeoIP: %s", err)
+	}
+	t.Cleanup(func() {
+		geoMutex.Lock()
+		geoDB.Close()
+		geoDB = nil
+		geoMutex.Unlock()
+	})
+}
+
+func TestLookupCountry(t *testing.T) {
+	withGeoIP(t, false)
+
+	for ip, want := range map[string]string{
+		"5.45.200.1": "RU",
+		"2.16.1.1":   "DE",
+		"1.0.16.5":   "JP",
+		"127.0.0.1":  "",
+		"not-an-ip":  "",
+	} {
+		if got := lookupCountry(ip); got != want {
+			t.Errorf("lookupCountry(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestResolveCountry(t *testing.T) {
+	tests := []struct {
+		name       string
+		override   bool
+		ip         string
+		requested  string
+		wantCode   string
+		wantSource string
+	}{
+		{"request wins", false, "5.45.200.1", "il", "IL", "request"},
+		{"geoip when request is empty", false, "5.45.200.1", "", "RU", "geoip"},
+		{"unknown ip, no request", false, "127.0.0.1", "", "", ""},
+		{"override mismatch uses geoip", true, "2.16.1.1", "RU", "DE", "geoip"},
+		{"override match", true, "2.16.1.1", "de", "DE", "geoip"},
+		{"override, unknown ip keeps request", true, "127.0.0.1", "RU", "RU", "request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withGeoIP(t, tt.override)
+
+			code, source := resolveCountry(tt.ip, tt.requested)
+			if code != tt.wantCode || source != tt.wantSource {
+				t.Errorf("resolveCountry(%q, %q) = %q, %q, want %q, %q",
+					tt.ip, tt.requested, code, source, tt.wantCode, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestResolveCountryWithoutDatabase(t *testing.T) {
+	withConfig(t, map[string]interface{}{"geoip.override": true})
+
+	if code, source := resolveCountry("5.45.200.1", "il"); code != "IL" || source != "request" {
+		t.Errorf("got %q, %q, want IL from request", code, source)
+	}
+}
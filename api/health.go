@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// confLoaded is set once a valid server config is in StrDB
+var confLoaded atomic.Bool
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// HealthStatus is the body of /healthz and /readyz
+type HealthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// getHealthz answers while the process is able to serve HTTP
+func getHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthStatus{Status: "ok"})
+}
+
+// getReadyz requires a loaded config, an MQTT connection
+// and at least one online server in every configured pool
+func getReadyz(c *gin.Context) {
+	status := HealthStatus{
+		Status: "ok",
+		Checks: map[string]HealthCheck{
+			"config": checkConfig(),
+			"mqtt":   checkMQTT(),
+			"pools":  checkPools(),
+		},
+	}
+
+	code := http.StatusOK
+	for _, check := range status.Checks {
+		if !check.OK {
+			status.Status = "fail"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, status)
+}
+
+func checkConfig() HealthCheck {
+	if !confLoaded.Load() {
+		return HealthCheck{Error: "server config is not loaded"}
+	}
+	return HealthCheck{OK: true}
+}
+
+func checkMQTT() HealthCheck {
+	if MQTT == nil || !MQTT.IsConnected() {
+		return HealthCheck{Error: "MQTT is not connected"}
+	}
+	return HealthCheck{OK: true}
+}
+
+// checkPools counts online servers per pool: global and every region entry of enabled servers
+func checkPools() HealthCheck {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	online := map[string]int{}
+	for _, server := range StrDB {
+		if !server.Enable {
+			continue
+		}
+
+		pools := []string(server.Region)
+		if server.Region.Global() {
+			pools = []string{PoolGlobal}
+		}
+		for _, pool := range pools {
+			if _, ok := online[pool]; !ok {
+				online[pool] = 0
+			}
+			if server.Online {
+				online[pool]++
+			}
+		}
+	}
+
+	if len(online) == 0 {
+		return HealthCheck{Error: "no enabled servers", Data: online}
+	}
+
+	var empty []string
+	for pool, count := range online {
+		if count == 0 {
+			empty = append(empty, pool)
+		}
+	}
+	if len(empty) > 0 {
+		sort.Strings(empty)
+		return HealthCheck{Error: fmt.Sprintf("no online servers in pools %v", empty), Data: online}
+	}
+
+	return HealthCheck{OK: true, Data: online}
+}
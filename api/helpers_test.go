@@ -0,0 +1,142 @@
+package api
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// withConfig resets viper and applies settings for the duration of the test
+func withConfig(t *testing.T, settings map[string]interface{}) {
+	t.Helper()
+	viper.Reset()
+	for k, v := range settings {
+		viper.Set(k, v)
+	}
+	setJanusSettings(nil)
+	t.Cleanup(func() {
+		viper.Reset()
+		setJanusSettings(nil)
+	})
+}
+
+// withServers replaces StrDB and clears runtime state kept next to it
+func withServers(t *testing.T, servers Config) {
+	t.Helper()
+	reset := func(conf Config) {
+		mutex.Lock()
+		StrDB = conf
+		pending = map[string][]time.Time{}
+		overrides = map[string]ServerOverride{}
+		adminRequests = map[string]adminRequest{}
+		if rnd == nil {
+			rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		mutex.Unlock()
+		stickyMutex.Lock()
+		assignments = map[string]StickyAssignment{}
+		stickyMutex.Unlock()
+		roomMutex.Lock()
+		rooms = map[string]*RoomPlacement{}
+		roomMutex.Unlock()
+		stateRestored.Store(false)
+	}
+	reset(servers)
+	t.Cleanup(func() { reset(Config{}) })
+}
+
+// published is a message sent through fakeBroker
+type published struct {
+	Topic    string
+	Retained bool
+	Payload  []byte
+	Props    *Properties
+}
+
+// fakeBroker records published messages instead of sending them
+type fakeBroker struct {
+	mu       sync.Mutex
+	messages []published
+	notify   chan published
+}
+
+func (b *fakeBroker) Connect() error { return nil }
+
+func (b *fakeBroker) Publish(topic string, retained bool, payload []byte, props *Properties) error {
+	p := published{Topic: topic, Retained: retained, Payload: payload, Props: props}
+	b.mu.Lock()
+	b.messages = append(b.messages, p)
+	b.mu.Unlock()
+	// Nobody may be waiting, messages of other tests must not block the outbox
+	select {
+	case b.notify <- p:
+	default:
+	}
+	return nil
+}
+
+func (b *fakeBroker) Subscribe(topic string, handler MessageHandler) error { return nil }
+func (b *fakeBroker) IsConnected() bool                                    { return true }
+func (b *fakeBroker) Disconnect()                                          {}
+
+// next waits for a message published to the topic
+func (b *fakeBroker) next(t *testing.T, topic string) published {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-b.notify:
+			if p.Topic == topic {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("nothing published to %s", topic)
+			return published{}
+		}
+	}
+}
+
+// none checks that nothing is published to the topic for a moment
+func (b *fakeBroker) none(t *testing.T, topic string) {
+	t.Helper()
+	timeout := time.After(200 * time.Millisecond)
+	for {
+		select {
+		case p := <-b.notify:
+			if p.Topic == topic {
+				t.Errorf("unexpected message on %s: %s", topic, p.Payload)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// testBroker is the MQTT client of all tests. It is installed once, before the
+// outbox starts, so MQTT is never swapped under a running publisher.
+var testBroker = &fakeBroker{notify: make(chan published, 1024)}
+
+func TestMain(m *testing.M) {
+	MQTT = testBroker
+	go runOutbox()
+	os.Exit(m.Run())
+}
+
+// withBroker forgets messages published before the test and returns the shared broker
+func withBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	testBroker.mu.Lock()
+	testBroker.messages = nil
+	testBroker.mu.Unlock()
+	for {
+		select {
+		case <-testBroker.notify:
+		default:
+			return testBroker
+		}
+	}
+}
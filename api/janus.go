@@ -0,0 +1,265 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// HealthOK - the server answers admin requests
+	HealthOK = "ok"
+	// HealthAdminError - the server answers admin requests with errors, e.g. wrong admin_secret
+	HealthAdminError = "admin_error"
+)
+
+const (
+	defaultAdminTimeout = 10 * time.Second
+	defaultInfoInterval = 5 * time.Minute
+)
+
+// Optional admin requests enabled in janus.collect
+const (
+	CollectInfo    = "info"
+	CollectStatus  = "get_status"
+	CollectHandles = "list_handles"
+)
+
+// JanusInfo is the health data collected from the Janus admin API
+// in addition to session count
+type JanusInfo struct {
+	Name       string                 `json:"name,omitempty"`
+	Version    string                 `json:"version,omitempty"`
+	Plugins    []string               `json:"plugins,omitempty"`
+	Transports []string               `json:"transports,omitempty"`
+	Status     map[string]interface{} `json:"status,omitempty"`
+	Handles    *int                   `json:"handles,omitempty"`
+	InfoAt     int64                  `json:"info_at,omitempty"`
+}
+
+// JanusModule is a plugin or transport in the info response
+type JanusModule struct {
+	Name          string `json:"name"`
+	VersionString string `json:"version_string"`
+}
+
+// adminRequest is a Janus admin request waiting for its response
+type adminRequest struct {
+	Server  string
+	Request string
+	SentAt  time.Time
+}
+
+var (
+	// adminRequests maps transaction to request, guarded by mutex
+	adminRequests = map[string]adminRequest{}
+
+	// txnPrefix tells our transactions from those of other strdb instances on the broker
+	txnPrefix  = newTxnPrefix()
+	txnCounter atomic.Uint64
+)
+
+func newTxnPrefix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "strdb-" + hex.EncodeToString(b)
+}
+
+// adminTimeout returns how long to wait for an admin response
+func adminTimeout() time.Duration {
+	return janusConfig().AdminTimeout
+}
+
+// adminMessage builds an admin request with a new transaction and registers
+// it as pending. Must be called with mutex held.
+func adminMessage(server string, request string) map[string]interface{} {
+	txn := fmt.Sprintf("%s-%d", txnPrefix, txnCounter.Add(1))
+	adminRequests[txn] = adminRequest{Server: server, Request: request, SentAt: time.Now()}
+
+	return map[string]interface{}{
+		"janus":        request,
+		"transaction":  txn,
+		"admin_secret": viper.GetString("mqtt.admin_secret"),
+	}
+}
+
+// takeAdminRequest returns and forgets the request of the transaction.
+// Must be called with mutex held.
+func takeAdminRequest(txn string) (adminRequest, bool) {
+	req, ok := adminRequests[txn]
+	if ok {
+		delete(adminRequests, txn)
+	}
+	return req, ok
+}
+
+// expireAdminRequests forgets requests left without response for longer than
+// the admin timeout, their late replies will be ignored. Must be called with mutex held.
+func expireAdminRequests() {
+	deadline := time.Now().Add(-adminTimeout())
+	for txn, req := range adminRequests {
+		if req.SentAt.Before(deadline) {
+			delete(adminRequests, txn)
+		}
+	}
+}
+
+// forgetAdminRequests drops pending requests of the server. Must be called with mutex held.
+func forgetAdminRequests(name string) {
+	for txn, req := range adminRequests {
+		if req.Server == name {
+			delete(adminRequests, txn)
+		}
+	}
+}
+
+var (
+	// infoSentAt holds the time of the last info/get_status request per server, guarded by mutex
+	infoSentAt = map[string]time.Time{}
+	// handleCounts holds handles per session per server, guarded by mutex
+	handleCounts = map[string]map[int64]int{}
+)
+
+// collects reports whether the optional admin request is enabled in janus.collect
+func collects(request string) bool {
+	for _, r := range janusConfig().Collect {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+// infoInterval returns how often info and get_status are requested
+func infoInterval() time.Duration {
+	return janusConfig().InfoInterval
+}
+
+// adminRequestsFor returns admin requests to send to the server on this poll.
+// Must be called with mutex held.
+func adminRequestsFor(name string) []map[string]interface{} {
+	messages := []map[string]interface{}{adminMessage(name, "list_sessions")}
+
+	if (collects(CollectInfo) || collects(CollectStatus)) && time.Since(infoSentAt[name]) >= infoInterval() {
+		infoSentAt[name] = time.Now()
+		if collects(CollectInfo) {
+			messages = append(messages, adminMessage(name, "info"))
+		}
+		if collects(CollectStatus) {
+			messages = append(messages, adminMessage(name, "get_status"))
+		}
+	}
+
+	return messages
+}
+
+// handlesRequests asks for handles of every session just listed.
+// Must be called with mutex held.
+func handlesRequests(name string, sessions []int64) []map[string]interface{} {
+	if !collects(CollectHandles) {
+		return nil
+	}
+
+	// Forget sessions which are gone
+	counts := map[int64]int{}
+	messages := make([]map[string]interface{}, 0, len(sessions))
+	for _, id := range sessions {
+		counts[id] = handleCounts[name][id]
+		message := adminMessage(name, "list_handles")
+		message["session_id"] = id
+		messages = append(messages, message)
+	}
+	handleCounts[name] = counts
+
+	return messages
+}
+
+// applyJanusResponse stores optional admin data on the server.
+// Must be called with mutex held.
+func applyJanusResponse(server *Server, request string, response *JanusResponse) {
+	info := JanusInfo{}
+	if server.Janus != nil {
+		info = *server.Janus
+	}
+
+	switch request {
+	case "info":
+		info.Name = response.Name
+		info.Version = response.VersionString
+		info.Plugins = moduleNames(response.Plugins)
+		info.Transports = moduleNames(response.Transports)
+		info.InfoAt = time.Now().Unix()
+	case "get_status":
+		info.Status = response.Status
+	case "list_handles":
+		counts, ok := handleCounts[server.Name]
+		if !ok {
+			return
+		}
+		if _, ok := counts[response.SessionID]; !ok {
+			return
+		}
+		counts[response.SessionID] = len(response.Handles)
+		total := 0
+		for _, n := range counts {
+			total += n
+		}
+		info.Handles = &total
+	default:
+		return
+	}
+
+	// Server records are copied by value, never modify the shared JanusInfo in place
+	server.Janus = &info
+}
+
+// forgetJanusInfo drops collected data of the server. Must be called with mutex held.
+func forgetJanusInfo(name string) {
+	delete(infoSentAt, name)
+	delete(handleCounts, name)
+	if server, ok := StrDB[name]; ok && server.Janus != nil {
+		server.Janus = nil
+		StrDB[name] = server
+	}
+}
+
+func moduleNames(modules map[string]JanusModule) []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasPlugins reports whether the server provides all plugins. A server whose
+// info was not collected yet is given the benefit of the doubt.
+func (s Server) HasPlugins(plugins []string) bool {
+	if len(plugins) == 0 || s.Janus == nil || s.Janus.InfoAt == 0 {
+		return true
+	}
+
+	for _, plugin := range plugins {
+		found := false
+		for _, p := range s.Janus.Plugins {
+			if p == plugin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.WithFields(log.Fields{
+				"server": s.Name,
+				"plugin": plugin,
+			}).Debug("[HasPlugins] Server is missing required plugin")
+			return false
+		}
+	}
+	return true
+}
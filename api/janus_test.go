@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// sendAdmin registers an admin request to the server and returns its transaction
+func sendAdmin(name string, request string) string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return adminMessage(name, request)["transaction"].(string)
+}
+
+// adminReply delivers a Janus admin reply of the server
+func adminReply(t *testing.T, name string, reply map[string]interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleAdmin(&Message{Topic: "janus/" + name + "/from-janus-admin", Payload: payload})
+}
+
+func adminServers() Config {
+	return Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Sessions: 5, MissedPing: 2, Enable: true, Online: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Sessions: 5, MissedPing: 2, Enable: true, Online: true},
+	}
+}
+
+func currentServer(name string) Server {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return StrDB[name]
+}
+
+func TestAdminReplyCorrelation(t *testing.T) {
+	sessions := func(txn string) map[string]interface{} {
+		return map[string]interface{}{"janus": "success", "transaction": txn, "sessions": []int64{1, 2, 3}}
+	}
+
+	t.Run("matching transaction", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, adminServers())
+
+		adminReply(t, "str1", sessions(sendAdmin("str1", "list_sessions")))
+		if s := currentServer("str1"); s.Sessions != 3 || s.MissedPing != 0 || s.LastSeen == 0 || s.Health != HealthOK {
+			t.Errorf("str1 = %+v, want 3 sessions, MissedPing reset and seen", s)
+		}
+	})
+
+	t.Run("unknown transaction", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, adminServers())
+
+		sendAdmin("str1", "list_sessions")
+		adminReply(t, "str1", sessions("strdb-other-1"))
+		adminReply(t, "str1", sessions(""))
+		if s := currentServer("str1"); s.Sessions != 5 || s.MissedPing != 2 {
+			t.Errorf("str1 = %+v, want unchanged", s)
+		}
+	})
+
+	t.Run("duplicate reply", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, adminServers())
+
+		txn := sendAdmin("str1", "list_sessions")
+		adminReply(t, "str1", sessions(txn))
+
+		mutex.Lock()
+		s := StrDB["str1"]
+		s.MissedPing = 2
+		StrDB["str1"] = s
+		mutex.Unlock()
+
+		adminReply(t, "str1", sessions(txn))
+		if s := currentServer("str1"); s.MissedPing != 2 {
+			t.Errorf("str1 MissedPing = %d after a duplicate reply, want 2", s.MissedPing)
+		}
+	})
+
+	t.Run("reply from another server", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, adminServers())
+
+		adminReply(t, "str1", sessions(sendAdmin("str2", "list_sessions")))
+		for _, name := range []string{"str1", "str2"} {
+			if s := currentServer(name); s.Sessions != 5 || s.MissedPing != 2 {
+				t.Errorf("%s = %+v, want unchanged", name, s)
+			}
+		}
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, adminServers())
+
+		adminReply(t, "str1", map[string]interface{}{
+			"janus":       "error",
+			"transaction": sendAdmin("str1", "list_sessions"),
+			"error":       map[string]interface{}{"code": 403, "reason": "Unauthorized request (wrong or missing secret/token)"},
+		})
+		s := currentServer("str1")
+		if s.Health != HealthAdminError || s.AdminError != "403: Unauthorized request (wrong or missing secret/token)" {
+			t.Errorf("str1 health %q, admin_error %q, want admin_error", s.Health, s.AdminError)
+		}
+		if s.MissedPing != 2 || s.Sessions != 5 {
+			t.Errorf("str1 MissedPing %d, sessions %d, want 2 and 5 unchanged", s.MissedPing, s.Sessions)
+		}
+
+		// The next good reply clears the error
+		adminReply(t, "str1", sessions(sendAdmin("str1", "list_sessions")))
+		if s := currentServer("str1"); s.Health != HealthOK || s.AdminError != "" || s.MissedPing != 0 {
+			t.Errorf("str1 = %+v, want healthy", s)
+		}
+	})
+}
+
+func TestExpireAdminRequests(t *testing.T) {
+	withConfig(t, map[string]interface{}{"janus.admin_timeout": "5s"})
+	withServers(t, adminServers())
+
+	late := sendAdmin("str1", "list_sessions")
+	fresh := sendAdmin("str2", "list_sessions")
+
+	mutex.Lock()
+	req := adminRequests[late]
+	req.SentAt = time.Now().Add(-10 * time.Second)
+	adminRequests[late] = req
+	expireAdminRequests()
+	_, lateKept := adminRequests[late]
+	_, freshKept := adminRequests[fresh]
+	mutex.Unlock()
+
+	if lateKept || !freshKept {
+		t.Fatalf("late kept %v, fresh kept %v, want only the fresh request", lateKept, freshKept)
+	}
+
+	// A reply after the timeout does not count as a ping
+	adminReply(t, "str1", map[string]interface{}{"janus": "success", "transaction": late, "sessions": []int64{}})
+	if s := currentServer("str1"); s.MissedPing != 2 || s.Sessions != 5 {
+		t.Errorf("str1 = %+v, want unchanged by a late reply", s)
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+)
+
+var (
+	assignmentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strdb_assignments_total",
+		Help: "Clients assigned to servers.",
+	}, []string{"server", "country", "pool", "reason"})
+
+	selectionFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strdb_selection_failures_total",
+		Help: "Server selections which found no server.",
+	}, []string{"country", "error"})
+
+	serverOfflineTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strdb_server_offline_total",
+		Help: "Servers marked offline for missing admin responses.",
+	}, []string{"server"})
+
+	adminErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strdb_admin_errors_total",
+		Help: "Janus admin requests answered with an error.",
+	}, []string{"server"})
+
+	adminRTT = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "strdb_admin_rtt_seconds",
+		Help:    "Janus admin request round-trip time.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"server"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "strdb_mqtt_connected",
+		Help: "1 if the MQTT connection is open.",
+	}, func() float64 {
+		if MQTT != nil && MQTT.IsConnected() {
+			return 1
+		}
+		return 0
+	})
+)
+
+func init() {
+	prometheus.MustRegister(serverCollector{})
+}
+
+// serverCollector exports StrDB state on every scrape, so removed servers disappear
+type serverCollector struct{}
+
+var (
+	serverSessionsDesc    = prometheus.NewDesc("strdb_server_sessions", "Janus sessions reported by the server.", []string{"server"}, nil)
+	serverPendingDesc     = prometheus.NewDesc("strdb_server_pending", "Assignments not yet reported by the server.", []string{"server"}, nil)
+	serverCapacityDesc    = prometheus.NewDesc("strdb_server_max_sessions", "Server capacity, 0 is unlimited.", []string{"server"}, nil)
+	serverOnlineDesc      = prometheus.NewDesc("strdb_server_online", "1 if the server is online.", []string{"server"}, nil)
+	serverEnabledDesc     = prometheus.NewDesc("strdb_server_enabled", "1 if the server is enabled.", []string{"server"}, nil)
+	serverDrainingDesc    = prometheus.NewDesc("strdb_server_draining", "1 if the server is draining.", []string{"server"}, nil)
+	serverMissedPingsDesc = prometheus.NewDesc("strdb_server_missed_pings", "Admin requests left without response.", []string{"server"}, nil)
+	serverLastSeenDesc    = prometheus.NewDesc("strdb_server_last_seen_age_seconds", "Seconds since the last admin response.", []string{"server"}, nil)
+	serverAdminErrorDesc  = prometheus.NewDesc("strdb_server_admin_error", "1 if the server answers admin requests with errors.", []string{"server"}, nil)
+)
+
+func (serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serverSessionsDesc
+	ch <- serverPendingDesc
+	ch <- serverCapacityDesc
+	ch <- serverOnlineDesc
+	ch <- serverEnabledDesc
+	ch <- serverDrainingDesc
+	ch <- serverMissedPingsDesc
+	ch <- serverLastSeenDesc
+	ch <- serverAdminErrorDesc
+}
+
+func (serverCollector) Collect(ch chan<- prometheus.Metric) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	now := time.Now()
+	for name, s := range StrDB {
+		ch <- prometheus.MustNewConstMetric(serverSessionsDesc, prometheus.GaugeValue, float64(s.Sessions), name)
+		ch <- prometheus.MustNewConstMetric(serverPendingDesc, prometheus.GaugeValue, float64(s.Pending), name)
+		ch <- prometheus.MustNewConstMetric(serverCapacityDesc, prometheus.GaugeValue, float64(s.MaxSessions), name)
+		ch <- prometheus.MustNewConstMetric(serverOnlineDesc, prometheus.GaugeValue, boolValue(s.Online), name)
+		ch <- prometheus.MustNewConstMetric(serverEnabledDesc, prometheus.GaugeValue, boolValue(s.Enable), name)
+		ch <- prometheus.MustNewConstMetric(serverDrainingDesc, prometheus.GaugeValue, boolValue(s.Draining), name)
+		ch <- prometheus.MustNewConstMetric(serverMissedPingsDesc, prometheus.GaugeValue, float64(s.MissedPing), name)
+		ch <- prometheus.MustNewConstMetric(serverAdminErrorDesc, prometheus.GaugeValue, boolValue(s.Health == HealthAdminError), name)
+		if s.LastSeen > 0 {
+			ch <- prometheus.MustNewConstMetric(serverLastSeenDesc, prometheus.GaugeValue, now.Sub(time.Unix(s.LastSeen, 0)).Seconds(), name)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// countryLabel bounds the country label cardinality: only countries listed
+// in metrics.countries get their own label, the rest are "other"
+func countryLabel(countryCode string) string {
+	if countryCode == "" {
+		return "unknown"
+	}
+	for _, c := range viper.GetStringSlice("metrics.countries") {
+		if strings.EqualFold(c, countryCode) {
+			return countryCode
+		}
+	}
+	return "other"
+}
+
+func getMetrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -3,32 +3,54 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"regexp"
 	"strings"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-var MQTT mqtt.Client
+var MQTT Broker
+
+// stopPoller stops startPeriodicMessages on shutdown
+var stopPoller = make(chan struct{})
 
 type MqttPayload struct {
 	Action  string      `json:"action,omitempty"`
 	ID      string      `json:"id,omitempty"`
 	Name    string      `json:"name,omitempty"`
 	Source  string      `json:"src,omitempty"`
-	Error   error       `json:"error,omitempty"`
+	Error   string      `json:"error,omitempty"`
 	Message string      `json:"message,omitempty"`
 	Result  string      `json:"result,omitempty"`
 	Data    interface{} `json:"data,omitempty"`
+	Token   string      `json:"token,omitempty"`    // Command authentication, never sent back
+	ReplyTo string      `json:"reply_to,omitempty"` // Command reply topic
 }
 
 type JanusResponse struct {
-	Janus       string  `json:"janus"`
-	Transaction string  `json:"transaction"`
-	Sessions    []int64 `json:"sessions"`
+	Janus       string      `json:"janus"`
+	Transaction string      `json:"transaction"`
+	Sessions    []int64     `json:"sessions"`
+	Error       *JanusError `json:"error,omitempty"`
+
+	// list_handles
+	SessionID int64   `json:"session_id,omitempty"`
+	Handles   []int64 `json:"handles,omitempty"`
+
+	// get_status
+	Status map[string]interface{} `json:"status,omitempty"`
+
+	// info, answered with "server_info"
+	Name          string                 `json:"name,omitempty"`
+	VersionString string                 `json:"version_string,omitempty"`
+	Plugins       map[string]JanusModule `json:"plugins,omitempty"`
+	Transports    map[string]JanusModule `json:"transports,omitempty"`
+}
+
+type JanusError struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
 }
 
 type PahoLogAdapter struct {
@@ -53,25 +75,20 @@ func (a *PahoLogAdapter) Printf(format string, v ...interface{}) {
 
 func InitMQTT() error {
 	log.Info("[InitMQTT] Init")
-	if viper.GetString("mqtt.debug") == "true" {
-		mqtt.DEBUG = NewPahoLogAdapter(log.DebugLevel)
-		mqtt.WARN = NewPahoLogAdapter(log.WarnLevel)
-	}
-	mqtt.CRITICAL = NewPahoLogAdapter(log.PanicLevel)
-	mqtt.ERROR = NewPahoLogAdapter(log.ErrorLevel)
-
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(viper.GetString("mqtt.url"))
-	opts.SetClientID(viper.GetString("mqtt.client_id"))
-	opts.SetUsername(viper.GetString("mqtt.user"))
-	opts.SetPassword(viper.GetString("mqtt.password"))
-	opts.SetAutoReconnect(true)
-	opts.SetOnConnectHandler(SubMQTT)
-	opts.SetConnectionLostHandler(LostMQTT)
-	opts.SetBinaryWill(viper.GetString("mqtt.status_topic"), []byte("Offline"), byte(1), true)
-	MQTT = mqtt.NewClient(opts)
-	if token := MQTT.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+
+	opts, err := brokerOptions()
+	if err != nil {
+		return err
+	}
+	broker, err := NewBroker(opts)
+	if err != nil {
+		return err
+	}
+
+	MQTT = broker
+	go runOutbox()
+	if err := MQTT.Connect(); err != nil {
+		return err
 	}
 
 	// Start Janus Admin messages sending
@@ -80,76 +97,132 @@ func InitMQTT() error {
 	return nil
 }
 
-const maxMissedPings = 3
-
+// startPeriodicMessages polls every server at its janus.poll_interval. Intervals
+// and thresholds are read on every tick, so config reloads apply without restart.
 func startPeriodicMessages() {
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(pollResolution)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-stopPoller:
+			log.Info("[startPeriodicMessages] Stopped")
+			return
+		case now := <-ticker.C:
 			mutex.Lock()
 			for name, server := range StrDB {
-				if !server.Enable || !server.Online {
+				if !server.Enable || !server.Online || !pollDue(name, now) {
 					continue
 				}
 
 				server.MissedPing++
 				StrDB[name] = server
 
-				if server.MissedPing > maxMissedPings {
+				if server.MissedPing > maxMissedPings(name) {
 					server.Online = false
 					server.Sessions = 0
+					server.OnlineSince = 0
 					StrDB[name] = server
 					log.WithFields(log.Fields{
 						"server":       name,
 						"missed_pings": server.MissedPing,
 						"last_seen":    server.LastSeen,
 					}).Warn("Server marked offline: no response to admin messages")
+					serverOfflineTotal.WithLabelValues(name).Inc()
+					forgetAdminRequests(name)
+					forgetJanusInfo(name)
+					delete(nextPoll, name)
+					clearAssignments(name)
+					clearRoomServer(name)
+					clearPending(name)
+					checkDrained(name)
+					emitServer(name)
+					publishServerEvent(name, false, "missed_pings")
 				} else {
 					topic := fmt.Sprintf("janus/%s/to-janus-admin", server.Name)
-					go SendAdminMessage(topic)
+					for _, message := range adminRequestsFor(name) {
+						go SendAdminMessage(topic, message)
+					}
 				}
 			}
+			prunePending()
+			expireAdminRequests()
 			mutex.Unlock()
+			pruneAssignments()
+			pruneRooms()
 		}
 	}
 }
 
-func SubMQTT(c mqtt.Client) {
-	if token := MQTT.Publish(viper.GetString("mqtt.status_topic"), byte(1), true, []byte("Online")); token.Wait() && token.Error() != nil {
-		log.Errorf("[SubMQTT] notify status error: %s", token.Error())
+func SubMQTT() {
+	if err := MQTT.Publish(viper.GetString("mqtt.status_topic"), true, []byte("Online"), nil); err != nil {
+		log.Errorf("[SubMQTT] notify status error: %s", err)
 	} else {
 		log.Infof("[SubMQTT] notify status to: %s", viper.GetString("mqtt.status_topic"))
 	}
 
+	// Retained state may be stale after a broker restart
+	publishAllServerStates()
+
 	StrStatusTopic := viper.GetString("mqtt.str_status_topic")
-	if token := MQTT.Subscribe(StrStatusTopic, byte(1), HandleStatusMessage); token.Wait() && token.Error() != nil {
-		log.Errorf("[SubMQTT] Subscribe error: %s", token.Error())
+	if err := MQTT.Subscribe(StrStatusTopic, HandleStatusMessage); err != nil {
+		log.Errorf("[SubMQTT] Subscribe error: %s", err)
 	} else {
 		log.Infof("[SubMQTT] Subscribed to: %s", StrStatusTopic)
 	}
 
 	StrAdminTopic := viper.GetString("mqtt.str_admin_topic")
-	if token := MQTT.Subscribe(StrAdminTopic, byte(1), HandleAdminMessage); token.Wait() && token.Error() != nil {
-		log.Errorf("[SubMQTT] Subscribe error: %s", token.Error())
+	if err := MQTT.Subscribe(StrAdminTopic, HandleAdminMessage); err != nil {
+		log.Errorf("[SubMQTT] Subscribe error: %s", err)
 	} else {
 		log.Infof("[SubMQTT] Subscribed to: %s", StrAdminTopic)
 	}
-}
 
-func LostMQTT(c mqtt.Client, err error) {
-	log.Errorf("[LostMQTT] Lost connection: %s", err)
+	if viper.GetString("mqtt.event_topic") != "" {
+		log.Warn("[SubMQTT] mqtt.event_topic is not supported, drained is published to <mqtt.events_topic>/drained")
+	}
+
+	if CmdTopic := viper.GetString("mqtt.cmd_topic"); CmdTopic != "" && !commandAuthConfigured() {
+		log.Errorf("[SubMQTT] Not subscribing to %s: set authentication.enable or mqtt.cmd_secret to allow commands", CmdTopic)
+	} else if CmdTopic != "" {
+		if err := MQTT.Subscribe(CmdTopic, HandleCommandMessage); err != nil {
+			log.Errorf("[SubMQTT] Subscribe error: %s", err)
+		} else {
+			log.Infof("[SubMQTT] Subscribed to: %s", CmdTopic)
+		}
+	}
+
+	if AssignTopic := viper.GetString("mqtt.assign_topic"); AssignTopic != "" {
+		if err := MQTT.Subscribe(AssignTopic, HandleAssignMessage); err != nil {
+			log.Errorf("[SubMQTT] Subscribe error: %s", err)
+		} else {
+			log.Infof("[SubMQTT] Subscribed to: %s", AssignTopic)
+		}
+	}
 }
 
-func SendAdminMessage(topic string) {
-	message := map[string]interface{}{
-		"janus":        "list_sessions",
-		"transaction":  "transaction",
-		"admin_secret": viper.GetString("mqtt.admin_secret"),
+// StopMQTT stops the poller, marks strdb offline on the status topic and disconnects
+func StopMQTT() {
+	close(stopPoller)
+
+	if MQTT == nil || !MQTT.IsConnected() {
+		return
+	}
+
+	if err := MQTT.Publish(viper.GetString("mqtt.status_topic"), true, []byte("Offline"), nil); err != nil {
+		log.Errorf("[StopMQTT] notify status error: %s", err)
+	} else {
+		log.Infof("[StopMQTT] notify status offline to: %s", viper.GetString("mqtt.status_topic"))
 	}
 
+	MQTT.Disconnect()
+}
+
+func LostMQTT(err error) {
+	log.Errorf("[LostMQTT] Lost connection: %s", err)
+}
+
+func SendAdminMessage(topic string, message map[string]interface{}) {
 	jsonMessage, err := json.Marshal(message)
 	if err != nil {
 		log.Errorf("[SendAdminMessage] Message parsing: %s", err)
@@ -160,40 +233,39 @@ func SendAdminMessage(topic string) {
 		log.Debugf("[SendAdminMessage] topic: %s | message: %s", topic, jsonMessage)
 	}
 
-	if token := MQTT.Publish(topic, byte(1), false, jsonMessage); token.Wait() && token.Error() != nil {
-		log.Errorf("[SendAdminMessage] Pubish: %s", token.Error())
+	if err := MQTT.Publish(topic, false, jsonMessage, nil); err != nil {
+		log.Errorf("[SendAdminMessage] Pubish: %s", err)
 	}
 }
 
-func HandleStatusMessage(c mqtt.Client, m mqtt.Message) {
+func HandleStatusMessage(m *Message) {
 	go func() {
-		s := strings.Split(m.Topic(), "/")
+		s := strings.Split(m.Topic, "/")
 		if len(s) < 2 {
-			log.Errorf("[HandleStatusMessage] Invalid topic format: %s", m.Topic())
+			log.Errorf("[HandleStatusMessage] Invalid topic format: %s", m.Topic)
 			return
 		}
 
 		serverName := s[1]
-		chk, _ := regexp.MatchString(`^str\d+$`, serverName)
-		if !chk {
+		if !knownServerName(serverName) {
 			log.WithFields(log.Fields{
-				"topic":       m.Topic(),
+				"topic":       m.Topic,
 				"server_name": serverName,
 			}).Warn("[HandleStatusMessage] Server name does not match pattern")
 			return
 		}
 
 		log.WithFields(log.Fields{
-			"topic":   m.Topic(),
+			"topic":   m.Topic,
 			"server":  serverName,
-			"payload": string(m.Payload()),
+			"payload": string(m.Payload),
 		}).Info("[HandleStatusMessage] Received status message")
 
 		var update StrStatus
-		if err := json.Unmarshal(m.Payload(), &update); err != nil {
+		if err := json.Unmarshal(m.Payload, &update); err != nil {
 			log.WithFields(log.Fields{
 				"server":  serverName,
-				"payload": string(m.Payload()),
+				"payload": string(m.Payload),
 				"error":   err.Error(),
 			}).Error("[HandleStatusMessage] Failed to unmarshal")
 			return
@@ -208,40 +280,110 @@ func HandleStatusMessage(c mqtt.Client, m mqtt.Message) {
 	}()
 }
 
-func HandleAdminMessage(c mqtt.Client, m mqtt.Message) {
+func HandleAdminMessage(m *Message) {
 	if viper.GetString("mqtt.trace") == "true" {
-		log.Debugf("[HandleAdminMessage] topic: %s | message: %s", m.Topic(), string(m.Payload()))
+		log.Debugf("[HandleAdminMessage] topic: %s | message: %s", m.Topic, string(m.Payload))
 	}
 
-	go func() {
-		s := strings.Split(m.Topic(), "/")
-		if len(s) < 2 {
-			log.Errorf("[HandleAdminMessage] Invalid topic format: %s", m.Topic())
-			return
-		}
+	go handleAdmin(m)
+}
 
-		serverName := s[1]
-		var response JanusResponse
-		if err := json.Unmarshal(m.Payload(), &response); err != nil {
-			log.Errorf("[HandleAdminMessage] Failed to unmarshal: %s", err)
-			return
+// handleAdmin applies an admin reply synchronously, HandleAdminMessage runs it off the MQTT client goroutine
+func handleAdmin(m *Message) {
+	s := strings.Split(m.Topic, "/")
+	if len(s) < 2 {
+		log.Errorf("[HandleAdminMessage] Invalid topic format: %s", m.Topic)
+		return
+	}
+
+	serverName := s[1]
+	var response JanusResponse
+	if err := json.Unmarshal(m.Payload, &response); err != nil {
+		log.Errorf("[HandleAdminMessage] Failed to unmarshal: %s", err)
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Only replies to our own pending requests count, stale, duplicated and
+	// foreign replies must not reset MissedPing
+	req, ok := takeAdminRequest(response.Transaction)
+	if !ok || req.Server != serverName {
+		log.WithFields(log.Fields{
+			"server":      serverName,
+			"transaction": response.Transaction,
+			"janus":       response.Janus,
+		}).Debug("[HandleAdminMessage] Ignoring reply with unknown transaction")
+		return
+	}
+
+	server, ok := StrDB[serverName]
+	if !ok {
+		return
+	}
+	before := server
+	rtt := time.Since(req.SentAt)
+	adminRTT.WithLabelValues(serverName).Observe(rtt.Seconds())
+	server.AdminRTT = float64(rtt.Microseconds()) / 1000
+
+	switch {
+	case response.Janus == "server_info" || (response.Janus == "success" && req.Request != "list_sessions"):
+		// Optional health data, session count and MissedPing are handled by list_sessions
+		server.Health = HealthOK
+		server.AdminError = ""
+		applyJanusResponse(&server, req.Request, &response)
+		StrDB[serverName] = server
+
+	case response.Janus == "success":
+		server.Sessions = len(response.Sessions)
+		server.MissedPing = 0
+		server.LastSeen = time.Now().Unix()
+		server.Health = HealthOK
+		server.AdminError = ""
+		StrDB[serverName] = server
+		settlePending(serverName)
+		checkDrained(serverName)
+		server = StrDB[serverName]
+
+		log.WithFields(log.Fields{
+			"server":   serverName,
+			"sessions": server.Sessions,
+			"pending":  server.Pending,
+			"online":   server.Online,
+			"rtt":      rtt,
+		}).Debug("[HandleAdminMessage] Updated server sessions")
+
+		topic := fmt.Sprintf("janus/%s/to-janus-admin", serverName)
+		for _, message := range handlesRequests(serverName, response.Sessions) {
+			go SendAdminMessage(topic, message)
 		}
 
-		if response.Janus == "success" {
-			mutex.Lock()
-			if server, ok := StrDB[serverName]; ok {
-				server.Sessions = len(response.Sessions)
-				server.MissedPing = 0
-				server.LastSeen = time.Now().Unix()
-				StrDB[serverName] = server
-
-				log.WithFields(log.Fields{
-					"server":   serverName,
-					"sessions": server.Sessions,
-					"online":   server.Online,
-				}).Debug("[HandleAdminMessage] Updated server sessions")
-			}
-			mutex.Unlock()
+	case response.Janus == "error":
+		// Janus is alive but refuses our requests, sessions are unknown
+		// so MissedPing is not reset
+		server.Health = HealthAdminError
+		if response.Error != nil {
+			server.AdminError = fmt.Sprintf("%d: %s", response.Error.Code, response.Error.Reason)
+		} else {
+			server.AdminError = "unknown error"
 		}
-	}()
+		StrDB[serverName] = server
+		adminErrorsTotal.WithLabelValues(serverName).Inc()
+
+		log.WithFields(log.Fields{
+			"server":  serverName,
+			"request": req.Request,
+			"error":   server.AdminError,
+		}).Error("[HandleAdminMessage] Janus admin request failed")
+
+	default:
+		StrDB[serverName] = server
+	}
+
+	if server.Sessions != before.Sessions || server.Pending != before.Pending ||
+		server.Health != before.Health || server.AdminError != before.AdminError ||
+		(server.Janus != before.Janus && req.Request != "list_handles") {
+		emitServer(serverName)
+	}
 }
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Servers changed at runtime through the admin API or MQTT commands keep their
+// changes across config reloads: the reloaded config is merged under these
+// overrides. An override is dropped once the config agrees with it, when it is
+// cleared with DELETE /admin/overrides/:name, or on restart.
+
+// ServerOverride is a runtime change of one server
+type ServerOverride struct {
+	Patch   ServerPatch `json:"patch"`             // Config fields set at runtime
+	Added   bool        `json:"added,omitempty"`   // Created at runtime, kept while missing in the config
+	Removed bool        `json:"removed,omitempty"` // Deleted at runtime, not re-added from the config
+}
+
+// overrides holds runtime changes per server, guarded by mutex
+var overrides = map[string]ServerOverride{}
+
+// fullPatch returns a patch setting every config field of the server
+func fullPatch(s Server) ServerPatch {
+	return ServerPatch{
+		DNS:         &s.DNS,
+		MaxSessions: &s.MaxSessions,
+		Weight:      &s.Weight,
+		Enable:      &s.Enable,
+		Region:      &s.Region,
+	}
+}
+
+// diffPatch returns a patch with the config fields that differ between the servers
+func diffPatch(before Server, after Server) ServerPatch {
+	var p ServerPatch
+	if before.DNS != after.DNS {
+		p.DNS = &after.DNS
+	}
+	if before.MaxSessions != after.MaxSessions {
+		p.MaxSessions = &after.MaxSessions
+	}
+	if before.Weight != after.Weight {
+		p.Weight = &after.Weight
+	}
+	if before.Enable != after.Enable {
+		p.Enable = &after.Enable
+	}
+	if !reflect.DeepEqual(before.Region, after.Region) {
+		p.Region = &after.Region
+	}
+	return p
+}
+
+// merge sets the fields of o which are set
+func (p *ServerPatch) merge(o ServerPatch) {
+	if o.DNS != nil {
+		p.DNS = o.DNS
+	}
+	if o.MaxSessions != nil {
+		p.MaxSessions = o.MaxSessions
+	}
+	if o.Weight != nil {
+		p.Weight = o.Weight
+	}
+	if o.Enable != nil {
+		p.Enable = o.Enable
+	}
+	if o.Region != nil {
+		p.Region = o.Region
+	}
+}
+
+// overrideAdded records a server created at runtime. Must be called with mutex held.
+func overrideAdded(server Server) {
+	overrides[server.Name] = ServerOverride{Patch: fullPatch(server), Added: true}
+}
+
+// overrideUpdated records the config fields changed at runtime. Must be called with mutex held.
+func overrideUpdated(before Server, after Server) {
+	o := overrides[after.Name]
+	o.Patch.merge(diffPatch(before, after))
+	overrides[after.Name] = o
+}
+
+// overrideRemoved records a server deleted at runtime. Must be called with mutex held.
+func overrideRemoved(name string) {
+	overrides[name] = ServerOverride{Removed: true}
+}
+
+// applyOverride returns the config server with its runtime override applied and
+// whether the override changed it. ok is false when the server was deleted at
+// runtime. Overrides the config agrees with are dropped. Must be called with mutex held.
+func applyOverride(server Server) (result Server, overridden bool, ok bool) {
+	o, found := overrides[server.Name]
+	if !found {
+		return server, false, true
+	}
+	if o.Removed {
+		return server, true, false
+	}
+
+	patched := server
+	o.Patch.apply(&patched)
+	if reflect.DeepEqual(patched, server) {
+		delete(overrides, server.Name)
+		return server, false, true
+	}
+	return patched, true, true
+}
+
+// keepAdded reports whether a server missing in the config was created at
+// runtime and stays. Must be called with mutex held.
+func keepAdded(name string) bool {
+	return overrides[name].Added
+}
+
+// pruneRemoved drops overrides of servers deleted at runtime which the config
+// no longer has. Must be called with mutex held.
+func pruneRemoved(conf Config) {
+	for name, o := range overrides {
+		if _, ok := conf[name]; o.Removed && !ok {
+			delete(overrides, name)
+		}
+	}
+}
+
+func getOverrides(c *gin.Context) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	c.JSON(http.StatusOK, overrides)
+}
+
+// deleteOverride forgets the runtime changes of the server, the config is
+// applied to it on the next reload
+func deleteOverride(c *gin.Context) {
+	name := c.Param("name")
+
+	mutex.Lock()
+	o, ok := overrides[name]
+	delete(overrides, name)
+	mutex.Unlock()
+
+	if !ok {
+		NewHttpError(http.StatusNotFound, ServerNotFound{Name: name}, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "reset_override", name, o, nil)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Session counts are refreshed only by the periodic list_sessions poll, so during
+// a burst every client would see the same least loaded server. Assignments made
+// since the last poll are counted as provisional sessions (Server.Pending) until
+// the next admin response shows them as real sessions or they time out.
+
+const (
+	defaultPendingTimeout = 30 * time.Second
+	defaultPendingGrace   = 2 * time.Second
+)
+
+// pending holds assignment times per server, guarded by mutex
+var pending = map[string][]time.Time{}
+
+// pendingTimeout returns how long an assignment counts as a provisional session, 0 disables accounting
+func pendingTimeout() time.Duration {
+	if viper.IsSet("routing.pending_timeout") {
+		return viper.GetDuration("routing.pending_timeout")
+	}
+	return defaultPendingTimeout
+}
+
+// pendingGrace returns how long an assignment survives an admin response,
+// the client may not have created its Janus session yet
+func pendingGrace() time.Duration {
+	if viper.IsSet("routing.pending_grace") {
+		return viper.GetDuration("routing.pending_grace")
+	}
+	return defaultPendingGrace
+}
+
+// addPending counts a new assignment to the server. Must be called with mutex held.
+func addPending(name string) {
+	if pendingTimeout() <= 0 {
+		return
+	}
+
+	server, ok := StrDB[name]
+	if !ok {
+		return
+	}
+
+	pending[name] = append(pending[name], time.Now())
+	server.Pending = len(pending[name])
+	StrDB[name] = server
+}
+
+// expirePending drops assignments made before the deadline. Must be called with mutex held.
+func expirePending(name string, deadline time.Time) {
+	times := pending[name]
+	i := 0
+	for i < len(times) && times[i].Before(deadline) {
+		i++
+	}
+	times = times[i:]
+
+	if len(times) == 0 {
+		delete(pending, name)
+	} else {
+		pending[name] = times
+	}
+
+	if server, ok := StrDB[name]; ok {
+		server.Pending = len(times)
+		StrDB[name] = server
+	}
+}
+
+// settlePending is called when a real session count arrives from the server.
+// Must be called with mutex held.
+func settlePending(name string) {
+	expirePending(name, time.Now().Add(-pendingGrace()))
+}
+
+// clearPending drops all assignments of the server. Must be called with mutex held.
+func clearPending(name string) {
+	expirePending(name, time.Now().Add(time.Hour))
+}
+
+// prunePending drops timed out assignments of all servers. Must be called with mutex held.
+func prunePending() {
+	deadline := time.Now().Add(-pendingTimeout())
+	for name := range pending {
+		expirePending(name, deadline)
+	}
+}
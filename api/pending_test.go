@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// burst sends concurrent assignment requests the way the simulate command
+// does and counts them per server
+func burst(t *testing.T, conf Config, requests int, concurrency int) map[string]int {
+	t.Helper()
+
+	result := Simulate(conf, requests, concurrency, "IL")
+	for err, n := range result.Errors {
+		t.Errorf("getBestServerForCountry: %s (%d times)", err, n)
+	}
+	return result.Servers
+}
+
+func testServers(sessions ...int) Config {
+	conf := Config{}
+	for i, s := range sessions {
+		name := fmt.Sprintf("str%d", i+1)
+		conf[name] = Server{Name: name, Sessions: s, MaxSessions: 1000, Enable: true, Online: true}
+	}
+	return conf
+}
+
+// spread returns the difference between the most and the least used server,
+// counting the sessions each server had before the burst
+func spread(conf Config, counts map[string]int) int {
+	min, max := -1, 0
+	for name, server := range conf {
+		total := server.Sessions + counts[name]
+		if min < 0 || total < min {
+			min = total
+		}
+		if total > max {
+			max = total
+		}
+	}
+	return max - min
+}
+
+func TestPendingSpreadsBurst(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []int
+	}{
+		{"idle servers", []int{0, 0, 0}},
+		{"uneven servers", []int{0, 50, 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, map[string]interface{}{"routing.pending_timeout": time.Minute})
+			conf := testServers(tt.sessions...)
+			withServers(t, conf)
+
+			counts := burst(t, conf, 300, 16)
+			if got := spread(conf, counts); got > 1 {
+				t.Errorf("spread = %d, want at most 1 (%v)", got, counts)
+			}
+
+			mutex.RLock()
+			defer mutex.RUnlock()
+			for name, server := range StrDB {
+				if server.Pending != counts[name] {
+					t.Errorf("%s pending = %d, want %d", name, server.Pending, counts[name])
+				}
+			}
+		})
+	}
+}
+
+func TestBurstWithoutPending(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.pending_timeout": 0})
+
+	// Equal load, every request is a random tie break
+	conf := testServers(0, 0, 0)
+	withServers(t, conf)
+	counts := burst(t, conf, 300, 16)
+	if got := spread(conf, counts); got > 100 {
+		t.Errorf("spread = %d, want at most 100 (%v)", got, counts)
+	}
+
+	// Without provisional sessions the whole burst lands on the least loaded server
+	conf = testServers(0, 50, 100)
+	withServers(t, conf)
+	counts = burst(t, conf, 300, 16)
+	if counts["str1"] != 300 {
+		t.Errorf("str1 got %d of 300 requests, want all (%v)", counts["str1"], counts)
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	defaultPollInterval   = 10 * time.Second
+	defaultMaxMissedPings = 3
+	defaultNamePattern    = `^str\d+$`
+
+	// pollResolution is how often the poller wakes up to check which servers are due
+	pollResolution = time.Second
+)
+
+var (
+	// nextPoll holds when each server is polled next, guarded by mutex
+	nextPoll = map[string]time.Time{}
+)
+
+// pollInterval returns how often the server is asked for list_sessions,
+// janus.servers.<name>.poll_interval overrides janus.poll_interval
+func pollInterval(name string) time.Duration {
+	s := janusConfig()
+	if i := s.Servers[strings.ToLower(name)].PollInterval; i > 0 {
+		return i
+	}
+	return s.PollInterval
+}
+
+// maxMissedPings returns how many unanswered polls mark the server offline,
+// janus.servers.<name>.max_missed_pings overrides janus.max_missed_pings
+func maxMissedPings(name string) int {
+	s := janusConfig()
+	if n := s.Servers[strings.ToLower(name)].MaxMissedPings; n > 0 {
+		return n
+	}
+	return s.MaxMissedPings
+}
+
+// pollDue reports whether the server should be polled now and schedules its next poll.
+// Must be called with mutex held.
+func pollDue(name string, now time.Time) bool {
+	if now.Before(nextPoll[name]) {
+		return false
+	}
+	nextPoll[name] = now.Add(pollInterval(name))
+	return true
+}
+
+// knownServerName reports whether status messages from the server are accepted.
+// janus.name_pattern is a regexp, an empty pattern accepts any server present in StrDB.
+func knownServerName(name string) bool {
+	re := janusConfig().NamePattern
+	if re == nil {
+		mutex.RLock()
+		_, ok := StrDB[name]
+		mutex.RUnlock()
+		return ok
+	}
+
+	return re.MatchString(name)
+}
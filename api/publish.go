@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Events published to <mqtt.events_topic>/<event>
+const (
+	EventServerOnline  = "server_online"
+	EventServerOffline = "server_offline"
+	EventAssignment    = "assignment"
+	EventPoolEmpty     = "pool_empty"
+	EventDrained       = "drained"
+)
+
+// ServerStateMessage is the retained payload of <mqtt.servers_topic>/<name>.
+// A removed server gets an empty retained payload, which clears the topic.
+type ServerStateMessage struct {
+	Server
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// ServerEvent is the payload of server_online and server_offline
+type ServerEvent struct {
+	Event  string `json:"event"`
+	Server string `json:"server"`
+	Reason string `json:"reason"` // "status" from the str status topic or "missed_pings"
+	Time   int64  `json:"time"`
+}
+
+// AssignmentEvent is the payload of assignment
+type AssignmentEvent struct {
+	Event   string `json:"event"`
+	Server  string `json:"server"`
+	Pool    string `json:"pool"`
+	Country string `json:"country,omitempty"`
+	User    string `json:"user,omitempty"` // Sticky key: "id:<id>", "vh:<id>" or "rfid:<rfid>"
+	Room    int    `json:"room,omitempty"`
+	Group   string `json:"group,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// PoolEmptyEvent is the payload of pool_empty, sent when no server could be assigned
+type PoolEmptyEvent struct {
+	Event   string `json:"event"`
+	Country string `json:"country,omitempty"`
+	Full    bool   `json:"full"` // Servers exist but are at max_sessions
+	User    string `json:"user,omitempty"`
+	Time    int64  `json:"time"`
+}
+
+// DrainedEvent is the payload of drained, sent when a draining server has no sessions left
+type DrainedEvent struct {
+	Event  string `json:"event"`
+	Server string `json:"server"`
+	Time   int64  `json:"time"`
+}
+
+const outboxSize = 1024
+
+type outboxMessage struct {
+	topic    string
+	retained bool
+	payload  []byte
+}
+
+// outbox keeps state and events in order without blocking callers holding mutex
+var outbox = make(chan outboxMessage, outboxSize)
+
+// runOutbox publishes queued messages. Blocks, run it in a goroutine.
+func runOutbox() {
+	for m := range outbox {
+		// Events are dropped while disconnected, retained state is refreshed on reconnect
+		if MQTT == nil || !MQTT.IsConnected() {
+			continue
+		}
+		if err := MQTT.Publish(m.topic, m.retained, m.payload, nil); err != nil {
+			log.WithFields(log.Fields{
+				"topic": m.topic,
+				"error": err.Error(),
+			}).Error("[runOutbox] Publish")
+		}
+	}
+}
+
+func enqueue(topic string, retained bool, payload []byte) {
+	select {
+	case outbox <- outboxMessage{topic: topic, retained: retained, payload: payload}:
+	default:
+		log.WithField("topic", topic).Warn("[enqueue] MQTT outbox is full, dropping message")
+	}
+}
+
+const (
+	defaultServersTopic = "strdb/servers"
+	defaultEventsTopic  = "strdb/events"
+)
+
+// topicPrefix returns the topic of the key without a trailing slash,
+// def when the key is not set. Set the key to "" to stop publishing.
+func topicPrefix(key string, def string) string {
+	prefix := def
+	if viper.IsSet(key) {
+		prefix = viper.GetString(key)
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// publishServerState queues the retained state of the server. Must be called with mutex held.
+func publishServerState(name string) {
+	prefix := topicPrefix("mqtt.servers_topic", defaultServersTopic)
+	if prefix == "" {
+		return
+	}
+
+	server, ok := StrDB[name]
+	if !ok {
+		enqueue(prefix+"/"+name, true, []byte{})
+		return
+	}
+
+	payload, err := json.Marshal(ServerStateMessage{Server: server, UpdatedAt: time.Now().Unix()})
+	if err != nil {
+		log.Errorf("[publishServerState] Message parsing: %s", err)
+		return
+	}
+	enqueue(prefix+"/"+name, true, payload)
+}
+
+// publishAllServerStates refreshes retained state of every server, used on (re)connect
+func publishAllServerStates() {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	for name := range StrDB {
+		publishServerState(name)
+	}
+}
+
+// publishEvent queues a non-retained event on <mqtt.events_topic>/<event>
+func publishEvent(event string, payload interface{}) {
+	prefix := topicPrefix("mqtt.events_topic", defaultEventsTopic)
+	if prefix == "" {
+		return
+	}
+
+	message, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorf("[publishEvent] Message parsing: %s", err)
+		return
+	}
+	enqueue(prefix+"/"+event, false, message)
+}
+
+func publishServerEvent(name string, online bool, reason string) {
+	event := EventServerOffline
+	if online {
+		event = EventServerOnline
+	}
+	publishEvent(event, ServerEvent{Event: event, Server: name, Reason: reason, Time: time.Now().Unix()})
+}
+
+func publishDrained(name string) {
+	publishEvent(EventDrained, DrainedEvent{Event: EventDrained, Server: name, Time: time.Now().Unix()})
+}
+
+// publishAssignment sends assignment on success and pool_empty when no server was found
+func publishAssignment(a *Assignment, err error, countryCode string, user *User) {
+	e := AssignmentEvent{Country: countryCode, User: userKey(user), Time: time.Now().Unix()}
+	if user != nil {
+		e.Room = int(user.Room)
+		e.Group = user.Group
+	}
+
+	if err != nil {
+		publishEvent(EventPoolEmpty, PoolEmptyEvent{
+			Event:   EventPoolEmpty,
+			Country: e.Country,
+			Full:    errors.Is(err, ErrAllServersFull),
+			User:    e.User,
+			Time:    e.Time,
+		})
+		return
+	}
+
+	e.Event = EventAssignment
+	e.Server = a.Server
+	e.Pool = a.Pool
+	publishEvent(EventAssignment, e)
+}
@@ -0,0 +1,42 @@
+package api
+
+import "testing"
+
+func TestPublishTopics(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		servers  string
+		events   string
+	}{
+		{"defaults", nil, "strdb/servers", "strdb/events"},
+		{"configured", map[string]interface{}{"mqtt.servers_topic": "gxy/servers/", "mqtt.events_topic": "gxy/events"}, "gxy/servers", "gxy/events"},
+		{"disabled", map[string]interface{}{"mqtt.servers_topic": "", "mqtt.events_topic": ""}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.settings)
+			if got := topicPrefix("mqtt.servers_topic", defaultServersTopic); got != tt.servers {
+				t.Errorf("servers topic = %q, want %q", got, tt.servers)
+			}
+			if got := topicPrefix("mqtt.events_topic", defaultEventsTopic); got != tt.events {
+				t.Errorf("events topic = %q, want %q", got, tt.events)
+			}
+		})
+	}
+}
+
+func TestServerStatePublishedByDefault(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true},
+	})
+	broker := withBroker(t)
+
+	SetOnline("str1", false)
+	if p := broker.next(t, "strdb/servers/str1"); !p.Retained {
+		t.Error("server state is not retained")
+	}
+	broker.next(t, "strdb/events/"+EventServerOffline)
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var errNotModified = errors.New("config not modified")
+
+var (
+	// Validators of the last remote config for conditional GET
+	cfgETag         string
+	cfgLastModified string
+	cfgMutex        sync.Mutex
+
+	// Serializes reloads triggered by ticker, SIGHUP and admin API
+	reloadMutex sync.Mutex
+)
+
+// ConfigDiff lists servers changed by a config reload. Overridden lists servers
+// whose config differs from their runtime changes, which are kept.
+type ConfigDiff struct {
+	Added      []string `json:"added"`
+	Removed    []string `json:"removed"`
+	Changed    []string `json:"changed"`
+	Overridden []string `json:"overridden"`
+}
+
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// ReloadConf fetches the config again and merges it into StrDB.
+// Config comes from server.cfg_url, or from conf.json when no URL is set.
+func ReloadConf() (*ConfigDiff, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	// janus.* settings are reloaded from the file, the rest needs a restart
+	if err := reloadJanusSettings(); err != nil {
+		log.Errorf("[ReloadConf] Read settings error: %s", err)
+	}
+
+	var conf *Config
+	var err error
+	if viper.GetString("server.cfg_url") != "" {
+		conf, err = getJson()
+	} else {
+		conf, err = getConf()
+	}
+
+	if errors.Is(err, errNotModified) {
+		log.Debug("[ReloadConf] Config not modified")
+		return &ConfigDiff{}, nil
+	}
+	if err != nil {
+		log.Errorf("[ReloadConf] Get conf error: %s", err)
+		return nil, err
+	}
+
+	diff, err := mergeConfig(*conf)
+	if err != nil {
+		log.Errorf("[ReloadConf] Merged config error: %s", err)
+		return nil, err
+	}
+	confLoaded.Store(true)
+	if diff.Empty() {
+		log.Info("[ReloadConf] Config reloaded, no changes")
+	} else {
+		log.WithFields(log.Fields{
+			"added":   diff.Added,
+			"removed": diff.Removed,
+			"changed": diff.Changed,
+		}).Info("[ReloadConf] Config reloaded")
+	}
+	if len(diff.Overridden) > 0 {
+		log.WithFields(log.Fields{
+			"servers": diff.Overridden,
+		}).Warn("[ReloadConf] Config not applied to servers changed at runtime, see /admin/overrides")
+	}
+
+	return diff, nil
+}
+
+// mergeConfig replaces StrDB with the new config, keeping runtime
+// fields of servers which stay in the config and runtime overrides.
+// The merged result is validated, StrDB stays unchanged when it is invalid.
+func mergeConfig(conf Config) (*ConfigDiff, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	diff := &ConfigDiff{}
+	merged := Config{}
+	for name, server := range conf {
+		server, overridden, keep := applyOverride(server)
+		if overridden {
+			diff.Overridden = append(diff.Overridden, name)
+		}
+		if !keep {
+			continue
+		}
+
+		current, ok := StrDB[name]
+		if !ok {
+			diff.Added = append(diff.Added, name)
+			merged[name] = server
+			continue
+		}
+
+		server = server.withRuntime(current)
+		if !reflect.DeepEqual(server, current) {
+			diff.Changed = append(diff.Changed, name)
+		}
+		merged[name] = server
+	}
+
+	for name, current := range StrDB {
+		if _, ok := merged[name]; !ok {
+			if keepAdded(name) {
+				merged[name] = current
+				continue
+			}
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	// Runtime overrides and servers added at runtime may clash with the new config
+	if err := ValidateConfig(merged); err != nil {
+		return nil, err
+	}
+
+	pruneRemoved(conf)
+	for _, name := range diff.Removed {
+		clearPending(name)
+		clearAssignments(name)
+		clearRoomServer(name)
+		forgetAdminRequests(name)
+		forgetJanusInfo(name)
+		delete(nextPoll, name)
+	}
+
+	StrDB = merged
+	for _, names := range [][]string{diff.Added, diff.Removed, diff.Changed} {
+		for _, name := range names {
+			emitServer(name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Overridden)
+	return diff, nil
+}
+
+// StartConfReloader reloads config every server.cfg_reload_interval (disabled when 0) and on SIGHUP.
+// Blocks, run it in a goroutine.
+func StartConfReloader() {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if interval := viper.GetDuration("server.cfg_reload_interval"); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		log.Infof("[StartConfReloader] Reloading config every %s", interval)
+	}
+
+	for {
+		select {
+		case <-tick:
+			ReloadConf()
+		case <-sighup:
+			log.Info("[StartConfReloader] SIGHUP received, reloading config")
+			ReloadConf()
+		}
+	}
+}
+
+func reloadConf(c *gin.Context) {
+	diff, err := ReloadConf()
+	if err != nil {
+		NewHttpError(http.StatusBadGateway, err, gin.ErrorTypePublic).Abort(c)
+		return
+	}
+
+	audit(c, "reload", "", nil, diff)
+	c.JSON(http.StatusOK, diff)
+}
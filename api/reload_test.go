@@ -0,0 +1,125 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeConfigKeepsRuntimeChanges(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	conf := Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", MaxSessions: 500, Enable: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true},
+		"str3": {Name: "str3", DNS: "str3.example.com", Enable: true},
+	}
+	withServers(t, Config{
+		"str1": conf["str1"],
+		"str2": conf["str2"],
+		"str3": conf["str3"],
+	})
+
+	if _, _, err := UpdateServer("str1", func(s *Server) { s.Enable = false }); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddServer(Server{Name: "str4", DNS: "str4.example.com", Enable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveServer("str2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config changes capacity of str1, runtime enable must stay
+	conf["str1"] = Server{Name: "str1", DNS: "str1.example.com", MaxSessions: 800, Enable: true}
+	diff, err := mergeConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"str1", "str2"}; !reflect.DeepEqual(diff.Overridden, want) {
+		t.Errorf("overridden = %v, want %v", diff.Overridden, want)
+	}
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 {
+		t.Errorf("added %v, removed %v, want none", diff.Added, diff.Removed)
+	}
+
+	mutex.RLock()
+	str1, ok1 := StrDB["str1"]
+	_, ok2 := StrDB["str2"]
+	_, ok4 := StrDB["str4"]
+	mutex.RUnlock()
+
+	if !ok1 || str1.Enable || str1.MaxSessions != 800 {
+		t.Errorf("str1 = %+v, want disabled with max_sessions 800", str1)
+	}
+	if ok2 {
+		t.Error("str2 deleted at runtime is back after reload")
+	}
+	if !ok4 {
+		t.Error("str4 added at runtime is gone after reload")
+	}
+}
+
+func TestMergeConfigDropsSettledOverrides(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true},
+	})
+
+	if _, _, err := UpdateServer("str1", func(s *Server) { s.Weight = 2 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveServer("str2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config now agrees with both runtime changes
+	diff, err := mergeConfig(Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Weight: 2, Enable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Overridden) != 0 {
+		t.Errorf("overridden = %v, want none", diff.Overridden)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if len(overrides) != 0 {
+		t.Errorf("overrides = %v, want none", overrides)
+	}
+}
+
+func TestMergeConfigValidatesMerged(t *testing.T) {
+	withConfig(t, map[string]interface{}{})
+	conf := Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true},
+	}
+	withServers(t, Config{"str1": conf["str1"]})
+
+	if err := AddServer(Server{Name: "str4", DNS: "str4.example.com", Enable: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Valid on its own, but str5 takes the DNS of str4 added at runtime
+	conf["str5"] = Server{Name: "str5", DNS: "STR4.example.com", Enable: true}
+	if err := ValidateConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mergeConfig(conf); err == nil {
+		t.Fatal("merge with duplicate dns succeeded")
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if _, ok := StrDB["str5"]; ok {
+		t.Error("str5 went live with a duplicate dns")
+	}
+	if _, ok := StrDB["str4"]; !ok {
+		t.Error("str4 added at runtime is gone")
+	}
+	if !overrides["str4"].Added {
+		t.Errorf("overrides = %v, want str4 kept", overrides)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -149,11 +150,21 @@ func getStatus(c *gin.Context) {
 }
 
 func getServer(c *gin.Context) {
-	srv, err := getBestServer()
+	countryCode, countrySource := resolveCountry(c.ClientIP(), "")
+
+	log.WithFields(log.Fields{
+		"ip":             c.ClientIP(),
+		"country_code":   countryCode,
+		"country_source": countrySource,
+	}).Info("Client requesting server")
+
+	a, err := getBestServerForCountry(countryCode, nil)
+	publishAssignment(a, err, countryCode, nil)
 	if err != nil {
-		c.AbortWithStatus(http.StatusNotFound)
+		c.AbortWithStatus(selectionErrorStatus(err))
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"server": srv})
+	c.JSON(http.StatusOK, gin.H{"server": a.Server, "pool": a.Pool})
 }
 
 func getServerByID(c *gin.Context) {
@@ -164,38 +175,61 @@ func getServerByID(c *gin.Context) {
 		return
 	}
 
-	// Get country code from Geo data
-	countryCode := t.Geo.CountryCode
+	a, err := assignServer(t, c.ClientIP())
+	if err != nil {
+		c.AbortWithStatus(selectionErrorStatus(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"server": a.Server, "pool": a.Pool})
+}
+
+// assignServer resolves the user country and selects a server for the user.
+// Shared by POST /server and the MQTT assignment topic.
+func assignServer(t *User, clientIP string) (*Assignment, error) {
+	// Get country code from Geo data, resolve by client IP when missing
+	countryCode, countrySource := resolveCountry(clientIP, t.Geo.CountryCode)
 
 	// Log client request details
 	log.WithFields(log.Fields{
-		"username":     t.Username,
-		"email":        t.Email,
-		"ip":           t.IP,
-		"country":      t.Country,
-		"country_code": countryCode,
-		"city":         t.Geo.City,
-		"region":       t.Geo.Region,
-		"room":         int(t.Room),
-		"rfid":         int64(t.RFID),
+		"username":       t.Username,
+		"email":          t.Email,
+		"ip":             t.IP,
+		"client_ip":      clientIP,
+		"country":        t.Country,
+		"country_code":   countryCode,
+		"country_source": countrySource,
+		"city":           t.Geo.City,
+		"region":         t.Geo.Region,
+		"room":           int(t.Room),
+		"rfid":           int64(t.RFID),
 	}).Info("Client requesting server")
 
-	srv, err := getBestServerForCountry(countryCode)
+	a, err := getBestServerForCountry(countryCode, t)
+	publishAssignment(a, err, countryCode, t)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"username":     t.Username,
 			"country_code": countryCode,
 			"error":        err.Error(),
 		}).Error("Failed to get server for client")
-		c.AbortWithStatus(http.StatusNotFound)
-		return
+		return nil, err
 	}
 
 	log.WithFields(log.Fields{
 		"username":        t.Username,
 		"country_code":    countryCode,
-		"assigned_server": srv,
+		"assigned_server": a.Server,
+		"pool_type":       a.Pool,
 	}).Info("Server assigned to client")
 
-	c.JSON(http.StatusOK, gin.H{"server": srv})
+	return a, nil
+}
+
+// selectionErrorStatus maps server selection errors to HTTP status codes
+func selectionErrorStatus(err error) int {
+	if errors.Is(err, ErrAllServersFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusNotFound
 }
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// RoomPlacement lists the servers hosting a room in the order they were taken.
+// A room spills to the next server only when the previous ones are full.
+type RoomPlacement struct {
+	Servers   []string `json:"servers"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+var (
+	rooms     = map[string]*RoomPlacement{}
+	roomMutex sync.RWMutex
+)
+
+const defaultRoomTTL = time.Hour
+
+// roomAffinity reports whether room-aware placement is enabled
+func roomAffinity() bool {
+	return viper.GetBool("routing.room_affinity")
+}
+
+// roomTTL returns how long an idle room keeps its servers
+func roomTTL() time.Duration {
+	if ttl := viper.GetDuration("routing.room_ttl"); ttl > 0 {
+		return ttl
+	}
+	return defaultRoomTTL
+}
+
+// roomKey returns the room the user joins, group is used when room is not set
+func roomKey(u *User) string {
+	if u == nil {
+		return ""
+	}
+	if u.Room != 0 {
+		return fmt.Sprintf("room:%d", int(u.Room))
+	}
+	if u.Group != "" {
+		return "group:" + u.Group
+	}
+	return ""
+}
+
+// roomServer returns the first server already hosting the room that is among
+// the available servers of the pool selection would take. A room spilled to a
+// fallback pool is not followed there once the preferred pool has servers again.
+// Must be called with mutex held.
+func roomServer(key string, pool string, available []Server) (*Assignment, bool) {
+	if key == "" || !roomAffinity() {
+		return nil, false
+	}
+
+	roomMutex.RLock()
+	defer roomMutex.RUnlock()
+
+	placement, ok := rooms[key]
+	if !ok {
+		return nil, false
+	}
+
+	for _, name := range placement.Servers {
+		for _, server := range available {
+			if server.Name == name {
+				return &Assignment{Server: server.Name, Pool: pool}, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// placeInRoom records that the room is hosted on the server
+func placeInRoom(key string, server string) {
+	if key == "" || !roomAffinity() {
+		return
+	}
+
+	roomMutex.Lock()
+	defer roomMutex.Unlock()
+
+	placement, ok := rooms[key]
+	if !ok {
+		placement = &RoomPlacement{}
+		rooms[key] = placement
+	}
+	placement.UpdatedAt = time.Now().Unix()
+
+	for _, name := range placement.Servers {
+		if name == server {
+			return
+		}
+	}
+	placement.Servers = append(placement.Servers, server)
+
+	if len(placement.Servers) > 1 {
+		log.WithFields(log.Fields{
+			"room":    key,
+			"server":  server,
+			"servers": placement.Servers,
+		}).Info("[placeInRoom] Room spilled to another server")
+	}
+}
+
+// clearRoomServer removes the server from all rooms
+func clearRoomServer(name string) {
+	roomMutex.Lock()
+	defer roomMutex.Unlock()
+
+	for key, placement := range rooms {
+		servers := placement.Servers[:0]
+		for _, s := range placement.Servers {
+			if s != name {
+				servers = append(servers, s)
+			}
+		}
+		placement.Servers = servers
+		if len(servers) == 0 {
+			delete(rooms, key)
+		}
+	}
+}
+
+// pruneRooms removes rooms without assignments for longer than room TTL
+func pruneRooms() {
+	deadline := time.Now().Add(-roomTTL()).Unix()
+	roomMutex.Lock()
+	defer roomMutex.Unlock()
+
+	for key, placement := range rooms {
+		if placement.UpdatedAt < deadline {
+			delete(rooms, key)
+		}
+	}
+}
+
+func getRooms(c *gin.Context) {
+	roomMutex.RLock()
+	defer roomMutex.RUnlock()
+
+	c.JSON(http.StatusOK, rooms)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRoomReturnsToRecoveredPool(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.fallback": []string{"global"}, "routing.room_affinity": true})
+	withServers(t, fallbackServers())
+
+	SetOnline("str1", false)
+	a, err := getBestServerForCountry("RU", &User{ID: "u1", Room: 1051})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str2" || a.Pool != PoolGlobal {
+		t.Fatalf("with str1 offline got %s/%s, want str2/global", a.Server, a.Pool)
+	}
+
+	SetOnline("str1", true)
+	a, err = getBestServerForCountry("RU", &User{ID: "u2", Room: 1051})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str1" || a.Pool != PoolRegional {
+		t.Fatalf("after str1 recovered got %s/%s, want str1/regional", a.Server, a.Pool)
+	}
+
+	// The room is on str1 now, participants follow it there
+	a, err = getBestServerForCountry("RU", &User{ID: "u3", Room: 1051})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str1" {
+		t.Errorf("next participant got %s, want str1", a.Server)
+	}
+}
+
+func TestRoomCoLocatesParticipants(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.room_affinity": true})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true, Online: true},
+		"str3": {Name: "str3", DNS: "str3.example.com", Enable: true, Online: true},
+	})
+
+	first, err := getBestServerForCountry("DE", &User{ID: "u0", Room: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 20; i++ {
+		a, err := getBestServerForCountry("DE", &User{ID: fmt.Sprintf("u%d", i), Room: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Server != first.Server {
+			t.Fatalf("participant %d got %s, want %s", i, a.Server, first.Server)
+		}
+	}
+}
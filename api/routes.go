@@ -1,9 +1,60 @@
 package api
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/Bnei-Baruch/strdb/utils"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
 
+// SetupRoutes registers routes with their auth policies:
+// assignment endpoints are public, status and read-only admin endpoints
+// require the operator or admin role, changes require the admin role.
+// Without authentication.enable changes and user assignments are refused
+// unless admin.insecure is set.
 func SetupRoutes(router *gin.Engine) {
+	viper.SetDefault("authentication.operator_role", "strdb_operator")
+	viper.SetDefault("authentication.admin_role", "strdb_admin")
+	operatorRole := viper.GetString("authentication.operator_role")
+	adminRole := viper.GetString("authentication.admin_role")
+
+	// Public
 	router.GET("/server", getServer)
-	router.GET("/status", getStatus)
 	router.POST("/server", getServerByID)
+	router.GET("/metrics", getMetrics())
+	router.GET("/healthz", getHealthz)
+	router.GET("/readyz", getReadyz)
+
+	// Operator, read-only
+	operator := router.Group("/", utils.RequireRole(operatorRole, adminRole))
+	operator.GET("/status", getStatus)
+	operator.GET("/status/rooms", getRooms)
+	operator.GET("/admin/servers/:name", getAdminServer)
+	operator.GET("/admin/overrides", getOverrides)
+
+	// Operator, streams, the token may also come in the URL or WebSocket subprotocol
+	stream := router.Group("/status", utils.StreamTokenMiddleware(), utils.RequireRole(operatorRole, adminRole))
+	stream.GET("/stream", getStatusStream)
+	stream.GET("/ws", getStatusWS)
+
+	// Operator, user identities
+	identities := router.Group("/admin", utils.RequireAuthentication("admin.insecure"), utils.RequireRole(operatorRole, adminRole))
+	identities.GET("/assignments", getAssignments)
+
+	// Admin
+	if !viper.GetBool("authentication.enable") {
+		if viper.GetBool("admin.insecure") {
+			log.Warn("[SetupRoutes] Authentication is disabled, admin API is open to everyone (admin.insecure)")
+		} else {
+			log.Warn("[SetupRoutes] Authentication is disabled, admin API changes and assignments are refused")
+		}
+	}
+	admin := router.Group("/admin", utils.RequireAuthentication("admin.insecure"), utils.RequireRole(adminRole))
+	admin.POST("/servers/:name", createServer)
+	admin.PUT("/servers/:name", replaceServer)
+	admin.PATCH("/servers/:name", patchServer)
+	admin.DELETE("/servers/:name", deleteServer)
+	admin.PUT("/servers/:name/drain", setDraining)
+	admin.DELETE("/overrides/:name", deleteOverride)
+	admin.POST("/reload", reloadConf)
 }
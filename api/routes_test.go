@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Bnei-Baruch/strdb/utils"
+	"github.com/coreos/go-oidc"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(verifier *oidc.IDTokenVerifier) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(utils.EnvMiddleware(verifier), utils.ErrorHandlingMiddleware())
+	SetupRoutes(router)
+	return router
+}
+
+func serve(router *gin.Engine, method string, path string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutesFailClosed(t *testing.T) {
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/admin/servers/str1", `{"dns": "str1.example.com"}`},
+		{http.MethodPut, "/admin/servers/str1", `{"dns": "str1.example.com"}`},
+		{http.MethodPatch, "/admin/servers/str1", `{"enable": false}`},
+		{http.MethodDelete, "/admin/servers/str1", ``},
+		{http.MethodPut, "/admin/servers/str1/drain", `{"draining": true}`},
+		{http.MethodPost, "/admin/reload", ``},
+		{http.MethodGet, "/admin/assignments", ``},
+	}
+
+	t.Run("authentication disabled", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, Config{"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true}})
+		router := newTestRouter(nil)
+
+		for _, r := range requests {
+			if w := serve(router, r.method, r.path, r.body, nil); w.Code != http.StatusForbidden {
+				t.Errorf("%s %s = %d, want %d", r.method, r.path, w.Code, http.StatusForbidden)
+			}
+		}
+
+		mutex.RLock()
+		defer mutex.RUnlock()
+		if server, ok := StrDB["str1"]; !ok || !server.Enable || server.Draining {
+			t.Errorf("server changed by refused requests: %+v", server)
+		}
+	})
+
+	t.Run("read-only routes stay open", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{})
+		withServers(t, Config{"str1": {Name: "str1", Enable: true, Online: true}})
+		router := newTestRouter(nil)
+
+		for _, path := range []string{"/status", "/status/rooms", "/admin/servers/str1", "/admin/overrides"} {
+			if w := serve(router, http.MethodGet, path, "", nil); w.Code != http.StatusOK {
+				t.Errorf("GET %s = %d, want %d", path, w.Code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("admin.insecure", func(t *testing.T) {
+		withConfig(t, map[string]interface{}{"admin.insecure": true})
+		withServers(t, Config{"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true}})
+		router := newTestRouter(nil)
+
+		if w := serve(router, http.MethodGet, "/admin/assignments", "", nil); w.Code != http.StatusOK {
+			t.Errorf("GET assignments = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if w := serve(router, http.MethodPatch, "/admin/servers/str1", `{"enable": false}`, nil); w.Code != http.StatusOK {
+			t.Errorf("PATCH = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if w := serve(router, http.MethodDelete, "/admin/servers/str1", "", nil); w.Code != http.StatusNoContent {
+			t.Errorf("DELETE = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+		}
+	})
+}
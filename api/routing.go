@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// PoolRegional is the pool of servers dedicated to the client's country
+	PoolRegional = "regional"
+	// PoolGlobal is the pool of servers without region restriction
+	PoolGlobal = "global"
+)
+
+// Assignment is the result of server selection
+type Assignment struct {
+	Server string `json:"server"`
+	Pool   string `json:"pool"`
+}
+
+// Regions is the list of country codes and group names a server is dedicated to.
+// It unmarshals from both a JSON string ("RU") and a JSON array (["LT", "LV", "EE"]),
+// empty list means a global server.
+type Regions []string
+
+func (r *Regions) UnmarshalJSON(data []byte) error {
+	// Try to unmarshal as string first
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*r = nil
+		if str != "" {
+			*r = Regions{strings.ToUpper(str)}
+		}
+		return nil
+	}
+
+	// If failed, try as list
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*r = nil
+	for _, code := range list {
+		if code != "" {
+			*r = append(*r, strings.ToUpper(code))
+		}
+	}
+	return nil
+}
+
+// MarshalJSON keeps the single string form for global and single-region servers
+func (r Regions) MarshalJSON() ([]byte, error) {
+	switch len(r) {
+	case 0:
+		return json.Marshal("")
+	case 1:
+		return json.Marshal(r[0])
+	default:
+		return json.Marshal([]string(r))
+	}
+}
+
+// Global reports whether the server has no region restriction
+func (r Regions) Global() bool {
+	return len(r) == 0
+}
+
+// Matches reports whether the regions cover the code - a client country code
+// or a pool name from a fallback policy. An entry matches when it equals the code
+// or names a group (configured or continent) the code belongs to.
+func (r Regions) Matches(code string, groups RegionGroups) bool {
+	for _, entry := range r {
+		if entry == code || groups.Contains(entry, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionGroups maps group names to sets of country codes. Configured in viper:
+//
+//	routing:
+//	  groups:
+//	    EU: ["DE", "FR", "NL"]
+//	    BALTICS: ["LT", "LV", "EE"]
+//
+// Continent groups are built-in and referenced as "continent:EU", "continent:SA" etc.
+type RegionGroups map[string]map[string]bool
+
+func regionGroups() RegionGroups {
+	groups := RegionGroups{}
+	for name, codes := range viper.GetStringMapStringSlice("routing.groups") {
+		members := map[string]bool{}
+		for _, code := range codes {
+			members[strings.ToUpper(code)] = true
+		}
+		// Viper keys are case-insensitive, group names are stored lowercased
+		groups[strings.ToUpper(name)] = members
+	}
+	return groups
+}
+
+// Contains reports whether the country code belongs to the named group
+func (g RegionGroups) Contains(group string, code string) bool {
+	if strings.HasPrefix(group, strings.ToUpper(continentPrefix)) {
+		return continents[code] == strings.TrimPrefix(group, strings.ToUpper(continentPrefix))
+	}
+	return g[group][code]
+}
+
+// RegionPolicy describes where clients of a country go when their regional
+// pool is empty or saturated. Configured in viper:
+//
+//	routing:
+//	  fallback: ["global"]            # default for all countries
+//	  strategy: "least_load"          # default selection strategy
+//	  regions:
+//	    RU: {fallback: ["EU", "global"], strategy: "p2c"}
+//	    CN: {strict: true}            # never leave the regional pool
+type RegionPolicy struct {
+	Fallback []string
+	Strict   bool
+	Strategy string   // Selection strategy, applies to every pool the country is served from
+	Plugins  []string // Janus plugins a server must provide, see janus.collect "info"
+}
+
+// regionPolicy returns the routing policy of a country. Without routing.fallback
+// a country with regional servers never leaves its regional pool, as before
+// fallback policies were introduced.
+func regionPolicy(countryCode string) RegionPolicy {
+	policy := RegionPolicy{}
+	if viper.IsSet("routing.fallback") {
+		policy.Fallback = viper.GetStringSlice("routing.fallback")
+	}
+
+	// Viper keys are case-insensitive, country codes are stored lowercased
+	key := "routing.regions." + strings.ToLower(countryCode)
+	if viper.IsSet(key + ".fallback") {
+		policy.Fallback = viper.GetStringSlice(key + ".fallback")
+	}
+	policy.Strategy = viper.GetString("routing.strategy")
+	if viper.IsSet(key + ".strategy") {
+		policy.Strategy = viper.GetString(key + ".strategy")
+	}
+	policy.Plugins = viper.GetStringSlice("routing.required_plugins")
+	if viper.IsSet(key + ".required_plugins") {
+		policy.Plugins = viper.GetStringSlice(key + ".required_plugins")
+	}
+	policy.Strict = viper.GetBool(key + ".strict")
+	if policy.Strict {
+		policy.Fallback = nil
+	}
+
+	return policy
+}
+
+// poolChain returns the ordered list of pools to try for a country.
+// Countries with dedicated servers start from their regional pool and follow
+// the region policy, all others use the global pool only.
+// Must be called with mutex held.
+func poolChain(countryCode string, groups RegionGroups) []string {
+	if countryCode == "" || !hasRegionalServers(countryCode, groups) {
+		return []string{PoolGlobal}
+	}
+
+	chain := []string{PoolRegional}
+	seen := map[string]bool{PoolRegional: true, countryCode: true}
+	for _, pool := range regionPolicy(countryCode).Fallback {
+		if strings.EqualFold(pool, PoolGlobal) {
+			pool = PoolGlobal
+		} else {
+			pool = strings.ToUpper(pool)
+		}
+		if pool == "" || seen[pool] {
+			continue
+		}
+		seen[pool] = true
+		chain = append(chain, pool)
+	}
+
+	return chain
+}
+
+// hasRegionalServers reports whether any server is configured for the country,
+// regardless of its current state. Must be called with mutex held.
+func hasRegionalServers(countryCode string, groups RegionGroups) bool {
+	for _, server := range StrDB {
+		if server.Region.Matches(countryCode, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// inPool reports whether the server belongs to the pool for the country
+func inPool(server Server, pool string, countryCode string, groups RegionGroups) bool {
+	switch pool {
+	case PoolGlobal:
+		return server.Region.Global()
+	case PoolRegional:
+		return server.Region.Matches(countryCode, groups)
+	default:
+		return server.Region.Matches(pool, groups)
+	}
+}
+
+// poolServers returns servers of the pool that can accept new clients
+// and the number of servers skipped because they are full.
+// Must be called with mutex held.
+func poolServers(pool string, countryCode string, groups RegionGroups) ([]Server, int) {
+	var servers []Server
+	full := 0
+	plugins := regionPolicy(countryCode).Plugins
+	for _, server := range StrDB {
+		if !server.Accepting() || !server.HasPlugins(plugins) || !inPool(server, pool, countryCode, groups) {
+			continue
+		}
+		if server.Full() {
+			full++
+			continue
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, full
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// regionServers are a RU server, a server of the EU group and a global one
+func regionServers() Config {
+	return Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true, Region: Regions{"RU"}},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true, Online: true},
+		"str3": {Name: "str3", DNS: "str3.example.com", Enable: true, Online: true, Region: Regions{"EU"}},
+	}
+}
+
+func TestPoolChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		country  string
+		want     []string
+	}{
+		{"no country", nil, "", []string{PoolGlobal}},
+		{"no regional servers", map[string]interface{}{"routing.fallback": []string{"EU"}}, "IL", []string{PoolGlobal}},
+		{"isolated by default", nil, "RU", []string{PoolRegional}},
+		{"default fallback", map[string]interface{}{"routing.fallback": []string{"global"}}, "RU", []string{PoolRegional, PoolGlobal}},
+		{"global in upper case", map[string]interface{}{"routing.fallback": []string{"GLOBAL"}}, "RU", []string{PoolRegional, PoolGlobal}},
+		{
+			"country fallback in order",
+			map[string]interface{}{
+				"routing.fallback":   []string{"global"},
+				"routing.regions.ru": map[string]interface{}{"fallback": []string{"eu", "Global"}},
+				"routing.groups":     map[string]interface{}{"eu": []string{"DE", "FR"}},
+			},
+			"RU",
+			[]string{PoolRegional, "EU", PoolGlobal},
+		},
+		{
+			"duplicates and own country skipped",
+			map[string]interface{}{"routing.fallback": []string{"global", "RU", "Global", ""}},
+			"RU",
+			[]string{PoolRegional, PoolGlobal},
+		},
+		{
+			"strict",
+			map[string]interface{}{
+				"routing.fallback":   []string{"global"},
+				"routing.regions.ru": map[string]interface{}{"strict": true, "fallback": []string{"global"}},
+			},
+			"RU",
+			[]string{PoolRegional},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.settings)
+			withServers(t, regionServers())
+
+			mutex.RLock()
+			defer mutex.RUnlock()
+			if got := poolChain(tt.country, regionGroups()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("poolChain(%q) = %v, want %v", tt.country, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackSelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		server   string
+		pool     string
+		err      error
+	}{
+		{"isolated by default", nil, "", "", ErrNoAvailableServers},
+		{"fallback to global", map[string]interface{}{"routing.fallback": []string{"GLOBAL"}}, "str2", PoolGlobal, nil},
+		{
+			"fallback to group before global",
+			map[string]interface{}{
+				"routing.fallback": []string{"EU", "global"},
+				"routing.groups":   map[string]interface{}{"eu": []string{"DE", "FR"}},
+			},
+			"str3", "EU", nil,
+		},
+		{
+			"strict",
+			map[string]interface{}{
+				"routing.fallback":   []string{"global"},
+				"routing.regions.ru": map[string]interface{}{"strict": true},
+			},
+			"", "", ErrNoAvailableServers,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.settings)
+			servers := regionServers()
+			str1 := servers["str1"]
+			str1.Online = false
+			servers["str1"] = str1
+			withServers(t, servers)
+
+			a, err := getBestServerForCountry("ru", nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if a.Server != tt.server || a.Pool != tt.pool {
+				t.Errorf("got %s/%s, want %s/%s", a.Server, a.Pool, tt.server, tt.pool)
+			}
+		})
+	}
+}
+
+func TestServerResponsePool(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.fallback": []string{"global"}})
+	withServers(t, regionServers())
+	router := newTestRouter(nil)
+
+	tests := []struct {
+		body string
+		want Assignment
+	}{
+		{`{"geo": {"country_code": "RU"}}`, Assignment{Server: "str1", Pool: PoolRegional}},
+		{`{"geo": {"country_code": "IL"}}`, Assignment{Server: "str2", Pool: PoolGlobal}},
+	}
+
+	for _, tt := range tests {
+		w := serve(router, http.MethodPost, "/server", tt.body, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /server %s = %d: %s", tt.body, w.Code, w.Body)
+		}
+		var got Assignment
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("POST /server %s = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestRegionsJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Regions
+		out  string
+	}{
+		{`"RU"`, Regions{"RU"}, `"RU"`},
+		{`"ru"`, Regions{"RU"}, `"RU"`},
+		{`""`, nil, `""`},
+		{`null`, nil, `""`},
+		{`[]`, nil, `""`},
+		{`["lt", "LV", "", "ee"]`, Regions{"LT", "LV", "EE"}, `["LT","LV","EE"]`},
+		{`["Baltics"]`, Regions{"BALTICS"}, `"BALTICS"`},
+		{`["continent:eu"]`, Regions{"CONTINENT:EU"}, `"CONTINENT:EU"`},
+	}
+
+	for _, tt := range tests {
+		var r Regions
+		if err := json.Unmarshal([]byte(tt.in), &r); err != nil {
+			t.Errorf("Unmarshal(%s): %s", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(r, tt.want) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.in, r, tt.want)
+		}
+
+		out, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.out {
+			t.Errorf("Marshal(%s) = %s, want %s", tt.in, out, tt.out)
+		}
+
+		var back Regions
+		if err := json.Unmarshal(out, &back); err != nil || !reflect.DeepEqual(back, r) {
+			t.Errorf("round trip of %s = %#v, %v, want %#v", tt.in, back, err, r)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`42`), new(Regions)); err == nil {
+		t.Error("Unmarshal(42) succeeded, want an error")
+	}
+}
+
+func TestServerRegionBackwardCompatible(t *testing.T) {
+	var conf Config
+	data := `{
+		"str1": {"name": "str1", "region": "RU"},
+		"str2": {"name": "str2", "region": ""},
+		"str3": {"name": "str3"},
+		"str4": {"name": "str4", "region": ["LT", "LV"]}
+	}`
+	if err := json.Unmarshal([]byte(data), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Regions{"str1": {"RU"}, "str2": nil, "str3": nil, "str4": {"LT", "LV"}}
+	for name, regions := range want {
+		if got := conf[name].Region; !reflect.DeepEqual(got, regions) {
+			t.Errorf("%s region = %#v, want %#v", name, got, regions)
+		}
+	}
+}
+
+func TestRegionsMatches(t *testing.T) {
+	// Viper stores keys lowercased, group names must still match in upper case
+	withConfig(t, map[string]interface{}{
+		"routing.groups": map[string]interface{}{"Baltics": []string{"lt", "LV", "EE"}},
+	})
+	groups := regionGroups()
+
+	tests := []struct {
+		regions Regions
+		code    string
+		want    bool
+	}{
+		{Regions{"RU"}, "RU", true},
+		{Regions{"RU"}, "UA", false},
+		{Regions{"LT", "LV"}, "LV", true},
+		{Regions{"BALTICS"}, "LT", true},
+		{Regions{"BALTICS"}, "EE", true},
+		{Regions{"BALTICS"}, "FI", false},
+		{Regions{"BALTICS"}, "BALTICS", true}, // group used as a fallback pool
+		{Regions{"CONTINENT:SA"}, "BR", true},
+		{Regions{"CONTINENT:SA"}, "SA", false}, // Saudi Arabia is in Asia
+		{Regions{"CONTINENT:AS"}, "SA", true},
+		{Regions{"CONTINENT:EU"}, "DE", true},
+		{Regions{"CONTINENT:EU"}, "US", false},
+		{Regions{"SA"}, "SA", true},
+		{nil, "RU", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.regions.Matches(tt.code, groups); got != tt.want {
+			t.Errorf("%v.Matches(%q) = %v, want %v", tt.regions, tt.code, got, tt.want)
+		}
+	}
+
+	if !groups.Contains("BALTICS", "LT") || groups.Contains("baltics", "LT") {
+		t.Errorf("groups = %v, want names stored in upper case", groups)
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Selector picks one server out of the usable servers of a pool.
+// Candidates are never empty and sorted by name. Set names the candidate
+// set, see candidateSet. Selectors are called with mutex held, so they may
+// keep state without extra locking.
+type Selector interface {
+	Select(set string, candidates []Server, user *User) (Server, string)
+}
+
+const (
+	StrategyLeastLoad      = "least_load"
+	StrategyWeightedRandom = "weighted_random"
+	StrategyPowerOfTwo     = "p2c"
+	StrategyRoundRobin     = "round_robin"
+	StrategyHash           = "hash"
+)
+
+var selectors = map[string]Selector{
+	StrategyLeastLoad:      leastLoadSelector{},
+	StrategyWeightedRandom: weightedRandomSelector{},
+	StrategyPowerOfTwo:     powerOfTwoSelector{},
+	StrategyRoundRobin:     &roundRobinSelector{next: map[string]int{}},
+	StrategyHash:           hashSelector{},
+}
+
+// candidateSet names the servers a country is served from in a pool. A pool name
+// like "regional" means different servers for every country, and required
+// plugins may differ per country too, so stateful selectors keep their state
+// per country and pool.
+func candidateSet(countryCode string, pool string) string {
+	return countryCode + "/" + pool
+}
+
+// getSelector returns selector by strategy name, unknown names fall back to least load
+func getSelector(strategy string) (Selector, string) {
+	strategy = strings.ToLower(strategy)
+	if strategy == "" || strategy == "least_sessions" {
+		strategy = StrategyLeastLoad
+	}
+
+	s, ok := selectors[strategy]
+	if !ok {
+		log.WithFields(log.Fields{
+			"strategy": strategy,
+		}).Warn("[getSelector] Unknown selection strategy, using least_load")
+		return selectors[StrategyLeastLoad], StrategyLeastLoad
+	}
+	return s, strategy
+}
+
+// leastLoadSelector picks the server with minimum utilization, ties are broken randomly
+type leastLoadSelector struct{}
+
+func (leastLoadSelector) Select(set string, candidates []Server, user *User) (Server, string) {
+	minLoad := candidates[0].Load()
+	minLoadServers := []Server{candidates[0]}
+
+	for _, server := range candidates[1:] {
+		load := server.Load()
+		if load < minLoad {
+			minLoad = load
+			minLoadServers = []Server{server}
+		} else if load == minLoad {
+			minLoadServers = append(minLoadServers, server)
+		}
+	}
+
+	// If we have multiple servers with the same minimum utilization, choose randomly
+	if len(minLoadServers) > 1 {
+		return minLoadServers[rnd.Intn(len(minLoadServers))],
+			fmt.Sprintf("random from %d servers with minimum load %.3f", len(minLoadServers), minLoad)
+	}
+	return minLoadServers[0], fmt.Sprintf("minimum load %.3f", minLoad)
+}
+
+// weightedRandomSelector picks a random server with probability proportional
+// to its free capacity
+type weightedRandomSelector struct{}
+
+func (weightedRandomSelector) Select(set string, candidates []Server, user *User) (Server, string) {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, server := range candidates {
+		w := server.Capacity() - float64(server.ExpectedSessions())
+		if w < 0 {
+			w = 0
+		}
+		weights[i] = w
+		total += w
+	}
+
+	if total <= 0 {
+		return candidates[rnd.Intn(len(candidates))], "uniform random"
+	}
+
+	r := rnd.Float64() * total
+	for i, w := range weights {
+		r -= w
+		if r < 0 {
+			return candidates[i], fmt.Sprintf("weighted random %.2f/%.2f", w, total)
+		}
+	}
+	return candidates[len(candidates)-1], "weighted random"
+}
+
+// powerOfTwoSelector picks two random servers and takes the less loaded one
+type powerOfTwoSelector struct{}
+
+func (powerOfTwoSelector) Select(set string, candidates []Server, user *User) (Server, string) {
+	if len(candidates) == 1 {
+		return candidates[0], "single candidate"
+	}
+
+	i := rnd.Intn(len(candidates))
+	j := rnd.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := candidates[i], candidates[j]
+	if b.Load() < a.Load() {
+		a, b = b, a
+	}
+	return a, fmt.Sprintf("power of two choices: %s over %s", a.Name, b.Name)
+}
+
+// roundRobinSelector cycles through the servers of each candidate set
+type roundRobinSelector struct {
+	next map[string]int
+}
+
+func (s *roundRobinSelector) Select(set string, candidates []Server, user *User) (Server, string) {
+	i := s.next[set] % len(candidates)
+	s.next[set] = i + 1
+	return candidates[i], fmt.Sprintf("round robin %d/%d", i+1, len(candidates))
+}
+
+// hashSelector maps a user to the same server while the pool does not change,
+// using rendezvous hashing so only users of a removed server move elsewhere.
+// Anonymous users are placed by least load.
+type hashSelector struct{}
+
+func (hashSelector) Select(set string, candidates []Server, user *User) (Server, string) {
+	key := userKey(user)
+	if key == "" {
+		server, reason := leastLoadSelector{}.Select(set, candidates, user)
+		return server, "no user key, " + reason
+	}
+
+	var best Server
+	var bestScore uint64
+	for i, server := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(server.Name))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = server, score
+		}
+	}
+	return best, "consistent hash of " + key
+}
+
+// sortServers orders servers by name so stateful and hashing selectors are stable
+func sortServers(servers []Server) {
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Name < servers[j].Name
+	})
+}
@@ -0,0 +1,30 @@
+package api
+
+import "testing"
+
+func TestRoundRobinPerCountry(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.strategy": StrategyRoundRobin})
+	withServers(t, Config{
+		"str1": {Name: "str1", Region: Regions{"RU"}, Enable: true, Online: true},
+		"str2": {Name: "str2", Region: Regions{"RU"}, Enable: true, Online: true},
+		"str3": {Name: "str3", Region: Regions{"CN"}, Enable: true, Online: true},
+		"str4": {Name: "str4", Region: Regions{"CN"}, Enable: true, Online: true},
+	})
+	prev := selectors[StrategyRoundRobin]
+	selectors[StrategyRoundRobin] = &roundRobinSelector{next: map[string]int{}}
+	t.Cleanup(func() { selectors[StrategyRoundRobin] = prev })
+
+	// Both countries use the "regional" pool, each must cycle through its own servers
+	want := []struct{ country, server string }{
+		{"RU", "str1"}, {"CN", "str3"}, {"RU", "str2"}, {"CN", "str4"}, {"RU", "str1"}, {"CN", "str3"},
+	}
+	for i, w := range want {
+		a, err := getBestServerForCountry(w.country, nil)
+		if err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+		if a.Server != w.server || a.Pool != PoolRegional {
+			t.Errorf("request %d from %s: got %s/%s, want %s/%s", i, w.country, a.Server, a.Pool, w.server, PoolRegional)
+		}
+	}
+}
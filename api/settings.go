@@ -0,0 +1,130 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// JanusSettings holds the janus.* settings. They are read on first use and
+// again on every config reload, so polling can be tuned without a restart.
+// The global viper is never re-read at runtime, other goroutines read it
+// without locking.
+type JanusSettings struct {
+	PollInterval   time.Duration
+	MaxMissedPings int
+	NamePattern    *regexp.Regexp // nil accepts any server present in StrDB
+	AdminTimeout   time.Duration
+	Collect        []string
+	InfoInterval   time.Duration
+	Servers        map[string]JanusServerSettings // janus.servers.<name>, names lowercased
+}
+
+// JanusServerSettings overrides polling of one server, zero values mean the global setting
+type JanusServerSettings struct {
+	PollInterval   time.Duration
+	MaxMissedPings int
+}
+
+var (
+	janusSettings      *JanusSettings
+	janusSettingsMutex sync.RWMutex
+)
+
+// janusConfig returns the current janus settings
+func janusConfig() *JanusSettings {
+	janusSettingsMutex.RLock()
+	s := janusSettings
+	janusSettingsMutex.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	janusSettingsMutex.Lock()
+	defer janusSettingsMutex.Unlock()
+	if janusSettings == nil {
+		janusSettings = loadJanusSettings(viper.GetViper())
+	}
+	return janusSettings
+}
+
+// setJanusSettings replaces the janus settings, nil makes them read from viper on next use
+func setJanusSettings(s *JanusSettings) {
+	janusSettingsMutex.Lock()
+	janusSettings = s
+	janusSettingsMutex.Unlock()
+}
+
+// reloadJanusSettings reads the janus settings from the config file again.
+// The file is read by a separate viper instance, so the global one stays untouched.
+func reloadJanusSettings() error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	setJanusSettings(loadJanusSettings(v))
+	return nil
+}
+
+func loadJanusSettings(v *viper.Viper) *JanusSettings {
+	s := &JanusSettings{
+		PollInterval:   defaultPollInterval,
+		MaxMissedPings: defaultMaxMissedPings,
+		AdminTimeout:   defaultAdminTimeout,
+		Collect:        v.GetStringSlice("janus.collect"),
+		InfoInterval:   defaultInfoInterval,
+		Servers:        map[string]JanusServerSettings{},
+	}
+	if i := v.GetDuration("janus.poll_interval"); i > 0 {
+		s.PollInterval = i
+	}
+	if n := v.GetInt("janus.max_missed_pings"); n > 0 {
+		s.MaxMissedPings = n
+	}
+	if t := v.GetDuration("janus.admin_timeout"); t > 0 {
+		s.AdminTimeout = t
+	}
+	if i := v.GetDuration("janus.info_interval"); i > 0 {
+		s.InfoInterval = i
+	}
+
+	src := defaultNamePattern
+	if v.IsSet("janus.name_pattern") {
+		src = v.GetString("janus.name_pattern")
+	}
+	if src != "" {
+		re, err := regexp.Compile(src)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"pattern": src,
+				"error":   err.Error(),
+			}).Error("[loadJanusSettings] Invalid janus.name_pattern, using default")
+			re = regexp.MustCompile(defaultNamePattern)
+		}
+		s.NamePattern = re
+	}
+
+	// Viper keys are case-insensitive, server names are stored lowercased
+	for name := range v.GetStringMap("janus.servers") {
+		key := "janus.servers." + name + "."
+		s.Servers[name] = JanusServerSettings{
+			PollInterval:   v.GetDuration(key + "poll_interval"),
+			MaxMissedPings: v.GetInt(key + "max_missed_pings"),
+		}
+	}
+
+	return s
+}
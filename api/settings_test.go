@@ -0,0 +1,61 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestReloadJanusSettings(t *testing.T) {
+	withConfig(t, map[string]interface{}{"janus.poll_interval": "20s"})
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	viper.SetConfigFile(path)
+
+	if got := pollInterval("str1"); got != 20*time.Second {
+		t.Fatalf("poll interval before reload = %s, want 20s", got)
+	}
+
+	err := os.WriteFile(path, []byte(`
+janus:
+  poll_interval: 5s
+  name_pattern: ""
+  collect: [info]
+  servers:
+    STR2:
+      poll_interval: 1s
+      max_missed_pings: 10
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadJanusSettings(); err != nil {
+		t.Fatalf("reloadJanusSettings: %s", err)
+	}
+
+	if got := pollInterval("str1"); got != 5*time.Second {
+		t.Errorf("str1 poll interval = %s, want 5s", got)
+	}
+	if got := pollInterval("str2"); got != time.Second {
+		t.Errorf("str2 poll interval = %s, want 1s", got)
+	}
+	if got := maxMissedPings("str1"); got != defaultMaxMissedPings {
+		t.Errorf("str1 max missed pings = %d, want %d", got, defaultMaxMissedPings)
+	}
+	if got := maxMissedPings("str2"); got != 10 {
+		t.Errorf("str2 max missed pings = %d, want 10", got)
+	}
+	if !collects(CollectInfo) || collects(CollectStatus) {
+		t.Errorf("collect = %v, want [info]", janusConfig().Collect)
+	}
+	if janusConfig().NamePattern != nil {
+		t.Error("empty name_pattern must accept servers present in StrDB")
+	}
+
+	// The global viper is not re-read
+	if got := viper.GetString("janus.poll_interval"); got != "20s" {
+		t.Errorf("viper janus.poll_interval = %q, want unchanged 20s", got)
+	}
+}
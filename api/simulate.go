@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SimulationResult counts where a burst of simulated clients was sent
+type SimulationResult struct {
+	Requests int            `json:"requests"`
+	Servers  map[string]int `json:"servers"`
+	Pools    map[string]int `json:"pools"`
+	Errors   map[string]int `json:"errors"`
+}
+
+// Simulate replaces StrDB with the config and sends a burst of concurrent
+// assignment requests through the regular selection path. Each simulated
+// client has its own user ID. Meant for the simulate command, not for a
+// running server.
+func Simulate(conf Config, requests int, concurrency int, countryCode string) SimulationResult {
+	mutex.Lock()
+	StrDB = conf
+	pending = map[string][]time.Time{}
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	mutex.Unlock()
+
+	result := SimulationResult{
+		Requests: requests,
+		Servers:  map[string]int{},
+		Pools:    map[string]int{},
+		Errors:   map[string]int{},
+	}
+	var resultMutex sync.Mutex
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				user := &User{ID: fmt.Sprintf("sim-%d", i), Geo: Geo{CountryCode: countryCode}}
+				a, err := getBestServerForCountry(countryCode, user)
+
+				resultMutex.Lock()
+				if err != nil {
+					result.Errors[err.Error()]++
+				} else {
+					result.Servers[a.Server]++
+					result.Pools[a.Pool]++
+				}
+				resultMutex.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < requests; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return result
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultStateInterval = 30 * time.Second
+	defaultStateMaxAge   = 2 * time.Minute
+)
+
+// stateRestored is set once a snapshot was read, from then on servers without
+// a fresh session count are not selected, see Server.Accepting
+var stateRestored atomic.Bool
+
+// RuntimeState is the snapshot written to state.file, so a restart does not
+// route with the stale sessions and online flags of the config
+type RuntimeState struct {
+	SavedAt     int64                       `json:"saved_at"`
+	Servers     map[string]ServerRuntime    `json:"servers"`
+	Assignments map[string]StickyAssignment `json:"assignments,omitempty"`
+}
+
+// ServerRuntime is the part of Server maintained by strdb itself
+type ServerRuntime struct {
+	Sessions    int   `json:"sessions"`
+	Online      bool  `json:"online"`
+	LastSeen    int64 `json:"last_seen"`
+	OnlineSince int64 `json:"online_since,omitempty"`
+	Draining    bool  `json:"draining"`
+	Drained     bool  `json:"drained"`
+}
+
+// stateMaxAge returns how old a snapshot or a server entry may be to be restored
+func stateMaxAge() time.Duration {
+	if a := viper.GetDuration("state.max_age"); a > 0 {
+		return a
+	}
+	return defaultStateMaxAge
+}
+
+// SaveState writes the runtime state to state.file, replacing it atomically
+func SaveState() error {
+	path := viper.GetString("state.file")
+	if path == "" {
+		return nil
+	}
+
+	state := RuntimeState{
+		SavedAt:     time.Now().Unix(),
+		Servers:     map[string]ServerRuntime{},
+		Assignments: map[string]StickyAssignment{},
+	}
+
+	mutex.RLock()
+	for name, s := range StrDB {
+		state.Servers[name] = ServerRuntime{
+			Sessions:    s.Sessions,
+			Online:      s.Online,
+			LastSeen:    s.LastSeen,
+			OnlineSince: s.OnlineSince,
+			Draining:    s.Draining,
+			Drained:     s.Drained,
+		}
+	}
+	mutex.RUnlock()
+
+	stickyMutex.RLock()
+	for key, a := range assignments {
+		state.Assignments[key] = a
+	}
+	stickyMutex.RUnlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// RestoreState applies the snapshot from state.file to StrDB. Sessions of an
+// online server are restored only if it answered within state.max_age, offline
+// and drain flags always. Sessions of the config are never trusted: servers
+// without a fresh entry, or all of them when the snapshot is older than
+// state.max_age, are not selected until their first list_sessions reply.
+func RestoreState() error {
+	path := viper.GetString("state.file")
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state RuntimeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	stateRestored.Store(true)
+
+	now := time.Now()
+	maxAge := stateMaxAge()
+	if now.Sub(time.Unix(state.SavedAt, 0)) > maxAge {
+		mutex.Lock()
+		for name, server := range StrDB {
+			server.Sessions = 0
+			server.LastSeen = 0
+			StrDB[name] = server
+		}
+		mutex.Unlock()
+
+		log.WithFields(log.Fields{
+			"saved_at": time.Unix(state.SavedAt, 0),
+			"max_age":  maxAge,
+		}).Info("[RestoreState] State is too old, ignoring it, servers wait for the first poll")
+		return nil
+	}
+
+	restored, stale := 0, 0
+	mutex.Lock()
+	for name, server := range StrDB {
+		rt, ok := state.Servers[name]
+		if !ok {
+			server.Sessions = 0
+			server.LastSeen = 0
+			StrDB[name] = server
+			stale++
+			continue
+		}
+
+		server.Draining = rt.Draining
+		server.Drained = rt.Drained
+		if !rt.Online {
+			server.Online = false
+			server.Sessions = 0
+			server.OnlineSince = 0
+			restored++
+		} else if rt.LastSeen > 0 && now.Sub(time.Unix(rt.LastSeen, 0)) <= maxAge {
+			server.Sessions = rt.Sessions
+			server.Online = rt.Online
+			server.LastSeen = rt.LastSeen
+			server.OnlineSince = rt.OnlineSince
+			restored++
+		} else {
+			server.Sessions = 0
+			server.LastSeen = 0
+			stale++
+		}
+		StrDB[name] = server
+	}
+	mutex.Unlock()
+
+	sticky := 0
+	stickyMutex.Lock()
+	for key, a := range state.Assignments {
+		if a.ExpiresAt > now.Unix() {
+			assignments[key] = a
+			sticky++
+		}
+	}
+	stickyMutex.Unlock()
+
+	log.WithFields(log.Fields{
+		"saved_at":    time.Unix(state.SavedAt, 0),
+		"servers":     restored,
+		"stale":       stale,
+		"assignments": sticky,
+	}).Info("[RestoreState] Runtime state restored")
+
+	return nil
+}
+
+// StartStateSaver saves the runtime state every state.interval when state.file is set.
+// Blocks, run it in a goroutine.
+func StartStateSaver() {
+	if viper.GetString("state.file") == "" {
+		return
+	}
+
+	interval := viper.GetDuration("state.interval")
+	if interval <= 0 {
+		interval = defaultStateInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Infof("[StartStateSaver] Saving state every %s", interval)
+	for range ticker.C {
+		if err := SaveState(); err != nil {
+			log.Errorf("[StartStateSaver] Save state error: %s", err)
+		}
+	}
+}
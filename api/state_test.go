@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeState(t *testing.T, state RuntimeState) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.json")
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// configServers are servers as conf.json describes them, with sessions strdb must not trust
+func configServers() Config {
+	return Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Sessions: 100, Enable: true, Online: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Sessions: 100, Enable: true, Online: true},
+		"str3": {Name: "str3", DNS: "str3.example.com", Sessions: 100, Enable: true, Online: true},
+		"str4": {Name: "str4", DNS: "str4.example.com", Sessions: 100, Enable: true, Online: true},
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	now := time.Now()
+	path := writeState(t, RuntimeState{
+		SavedAt: now.Unix(),
+		Servers: map[string]ServerRuntime{
+			"str1": {Sessions: 7, Online: true, LastSeen: now.Unix(), OnlineSince: now.Add(-time.Hour).Unix()},
+			"str2": {Sessions: 9, Online: true, LastSeen: now.Add(-time.Hour).Unix(), Draining: true},
+			"str3": {Online: false},
+		},
+		Assignments: map[string]StickyAssignment{
+			"id:u1": {Server: "str1", Pool: PoolGlobal, ExpiresAt: now.Add(time.Minute).Unix()},
+			"id:u2": {Server: "str1", Pool: PoolGlobal, ExpiresAt: now.Add(-time.Minute).Unix()},
+		},
+	})
+	withConfig(t, map[string]interface{}{"state.file": path, "state.max_age": "2m"})
+	withServers(t, configServers())
+
+	if err := RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	want := map[string]struct {
+		sessions int
+		online   bool
+		draining bool
+	}{
+		"str1": {7, true, false}, // fresh
+		"str2": {0, true, true},  // stale, drain flag still restored
+		"str3": {0, false, false},
+		"str4": {0, true, false}, // not in the snapshot
+	}
+	for name, w := range want {
+		s := StrDB[name]
+		if s.Sessions != w.sessions || s.Online != w.online || s.Draining != w.draining {
+			t.Errorf("%s: sessions %d, online %v, draining %v, want %+v", name, s.Sessions, s.Online, s.Draining, w)
+		}
+	}
+
+	stickyMutex.RLock()
+	defer stickyMutex.RUnlock()
+	if _, ok := assignments["id:u1"]; !ok || len(assignments) != 1 {
+		t.Errorf("assignments = %v, want only id:u1", assignments)
+	}
+}
+
+func TestRestoreStateTooOld(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	path := writeState(t, RuntimeState{
+		SavedAt: old.Unix(),
+		Servers: map[string]ServerRuntime{
+			"str1": {Sessions: 7, Online: true, LastSeen: old.Unix(), Draining: true},
+		},
+	})
+	withConfig(t, map[string]interface{}{"state.file": path, "state.max_age": "2m"})
+	withServers(t, configServers())
+
+	if err := RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for name, s := range StrDB {
+		if s.Sessions != 0 || s.Draining {
+			t.Errorf("%s: sessions %d, draining %v, want 0 sessions and nothing restored", name, s.Sessions, s.Draining)
+		}
+	}
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	withConfig(t, map[string]interface{}{"state.file": path})
+	servers := configServers()
+	s := servers["str1"]
+	s.LastSeen = time.Now().Unix()
+	s.Sessions = 42
+	servers["str1"] = s
+	withServers(t, servers)
+
+	if err := SaveState(); err != nil {
+		t.Fatal(err)
+	}
+	withServers(t, configServers())
+	if err := RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if got := StrDB["str1"].Sessions; got != 42 {
+		t.Errorf("str1 sessions = %d, want 42", got)
+	}
+	if got := StrDB["str2"].Sessions; got != 0 {
+		t.Errorf("str2 sessions = %d, want 0 (never seen)", got)
+	}
+}
+
+func TestRestoreStateRouting(t *testing.T) {
+	now := time.Now()
+	path := writeState(t, RuntimeState{
+		SavedAt: now.Unix(),
+		Servers: map[string]ServerRuntime{
+			"str1": {Sessions: 500, Online: true, LastSeen: now.Unix()},
+		},
+	})
+	withConfig(t, map[string]interface{}{"state.file": path, "state.max_age": "2m", "routing.pending_timeout": "1m"})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", MaxSessions: 1000, Enable: true, Online: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Sessions: 900, MaxSessions: 1000, Enable: true, Online: true},
+	})
+
+	if err := RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// str2 has no fresh entry, its sessions are unknown until it is polled
+	for i := 0; i < 300; i++ {
+		a, err := getBestServerForCountry("", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Server != "str1" {
+			t.Fatalf("assignment %d went to %s before its first poll, want str1", i, a.Server)
+		}
+	}
+
+	// The first list_sessions reply makes str2 selectable again
+	mutex.Lock()
+	s := StrDB["str2"]
+	s.Sessions = 0
+	s.LastSeen = time.Now().Unix()
+	StrDB["str2"] = s
+	mutex.Unlock()
+
+	a, err := getBestServerForCountry("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str2" {
+		t.Errorf("after its poll got %s, want the empty str2", a.Server)
+	}
+}
+
+func TestRestoreStateTooOldRouting(t *testing.T) {
+	path := writeState(t, RuntimeState{SavedAt: time.Now().Add(-time.Hour).Unix()})
+	withConfig(t, map[string]interface{}{"state.file": path, "state.max_age": "2m"})
+	withServers(t, configServers())
+
+	if err := RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getBestServerForCountry("", nil); err != ErrNoAvailableServers {
+		t.Errorf("before any poll got %v, want %v", err, ErrNoAvailableServers)
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// StickyAssignment remembers the server a user was sent to,
+// so a reconnecting user gets the same server back
+type StickyAssignment struct {
+	Server     string `json:"server"`
+	Pool       string `json:"pool"`
+	AssignedAt int64  `json:"assigned_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+var (
+	assignments = map[string]StickyAssignment{}
+	stickyMutex sync.RWMutex
+)
+
+// stickyTTL returns how long an assignment is kept, 0 disables sticky assignment
+func stickyTTL() time.Duration {
+	return viper.GetDuration("sticky.ttl")
+}
+
+// userKey returns the identity a sticky assignment is stored under
+func userKey(u *User) string {
+	if u == nil {
+		return ""
+	}
+	if u.ID != "" {
+		return "id:" + u.ID
+	}
+	if u.VHInfo.ID != "" {
+		return "vh:" + u.VHInfo.ID
+	}
+	if u.RFID != 0 {
+		return fmt.Sprintf("rfid:%d", int64(u.RFID))
+	}
+	return ""
+}
+
+// stickyServer returns the previous assignment of the user if its server is
+// among the available servers of the pool selection would take, so a user sent
+// to a fallback pool moves back once their own pool has servers again.
+// Any other assignment is dropped. Must be called with mutex held.
+func stickyServer(key string, pool string, available []Server) (*Assignment, bool) {
+	if key == "" || stickyTTL() <= 0 {
+		return nil, false
+	}
+
+	stickyMutex.Lock()
+	defer stickyMutex.Unlock()
+
+	prev, ok := assignments[key]
+	if !ok {
+		return nil, false
+	}
+	if prev.ExpiresAt >= time.Now().Unix() {
+		for _, server := range available {
+			if server.Name == prev.Server {
+				return &Assignment{Server: server.Name, Pool: pool}, true
+			}
+		}
+	}
+
+	delete(assignments, key)
+	return nil, false
+}
+
+// rememberAssignment stores or refreshes the user's assignment
+func rememberAssignment(key string, a *Assignment) {
+	ttl := stickyTTL()
+	if key == "" || ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	stickyMutex.Lock()
+	defer stickyMutex.Unlock()
+
+	assignedAt := now.Unix()
+	if prev, ok := assignments[key]; ok && prev.Server == a.Server {
+		assignedAt = prev.AssignedAt
+	}
+	assignments[key] = StickyAssignment{
+		Server:     a.Server,
+		Pool:       a.Pool,
+		AssignedAt: assignedAt,
+		ExpiresAt:  now.Add(ttl).Unix(),
+	}
+}
+
+// clearAssignments forgets all users assigned to the server
+func clearAssignments(name string) {
+	stickyMutex.Lock()
+	defer stickyMutex.Unlock()
+
+	cleared := 0
+	for key, a := range assignments {
+		if a.Server == name {
+			delete(assignments, key)
+			cleared++
+		}
+	}
+
+	if cleared > 0 {
+		log.WithFields(log.Fields{
+			"server":  name,
+			"cleared": cleared,
+		}).Info("[clearAssignments] Sticky assignments cleared")
+	}
+}
+
+// pruneAssignments removes expired assignments
+func pruneAssignments() {
+	now := time.Now().Unix()
+	stickyMutex.Lock()
+	defer stickyMutex.Unlock()
+
+	for key, a := range assignments {
+		if a.ExpiresAt < now {
+			delete(assignments, key)
+		}
+	}
+}
+
+func getAssignments(c *gin.Context) {
+	stickyMutex.RLock()
+	defer stickyMutex.RUnlock()
+
+	c.JSON(http.StatusOK, assignments)
+}
@@ -0,0 +1,73 @@
+package api
+
+import "testing"
+
+// fallbackServers are a RU server and a global one the RU pool falls back to
+func fallbackServers() Config {
+	return Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true, Region: Regions{"RU"}},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true, Online: true},
+	}
+}
+
+func TestStickyReturnsToRecoveredPool(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.fallback": []string{"global"}, "sticky.ttl": "10m"})
+	withServers(t, fallbackServers())
+	user := &User{ID: "u1"}
+
+	SetOnline("str1", false)
+	a, err := getBestServerForCountry("RU", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str2" || a.Pool != PoolGlobal {
+		t.Fatalf("with str1 offline got %s/%s, want str2/global", a.Server, a.Pool)
+	}
+
+	// Still on the fallback pool, the user keeps their server
+	a, err = getBestServerForCountry("RU", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str2" {
+		t.Fatalf("reconnect got %s, want str2", a.Server)
+	}
+
+	SetOnline("str1", true)
+	a, err = getBestServerForCountry("RU", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Server != "str1" || a.Pool != PoolRegional {
+		t.Fatalf("after str1 recovered got %s/%s, want str1/regional", a.Server, a.Pool)
+	}
+
+	stickyMutex.RLock()
+	defer stickyMutex.RUnlock()
+	if got := assignments[userKey(user)].Server; got != "str1" {
+		t.Errorf("sticky assignment = %s, want str1", got)
+	}
+}
+
+func TestStickyKeepsServerInPreferredPool(t *testing.T) {
+	withConfig(t, map[string]interface{}{"sticky.ttl": "10m"})
+	withServers(t, Config{
+		"str1": {Name: "str1", DNS: "str1.example.com", Enable: true, Online: true},
+		"str2": {Name: "str2", DNS: "str2.example.com", Enable: true, Online: true},
+	})
+	user := &User{ID: "u1"}
+
+	first, err := getBestServerForCountry("DE", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		a, err := getBestServerForCountry("DE", user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Server != first.Server {
+			t.Fatalf("reconnect %d got %s, want %s", i, a.Server, first.Server)
+		}
+	}
+}
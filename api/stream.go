@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Bnei-Baruch/strdb/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const streamPingInterval = 15 * time.Second
+
+// getStatusStream streams status events as Server-Sent Events
+func getStatusStream(c *gin.Context) {
+	events := subscribe()
+	defer unsubscribe(events)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-ping.C:
+			// SSE comment keeps proxies from closing an idle connection
+			w.Write([]byte(": ping\n\n"))
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+var upgrader = websocket.Upgrader{
+	// Origins are checked by CORS and auth policies like for the rest of the API
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// getStatusWS streams status events over WebSocket, one JSON message per event
+func getStatusWS(c *gin.Context) {
+	// A browser drops the connection unless one of its offered subprotocols is accepted
+	var header http.Header
+	for _, p := range websocket.Subprotocols(c.Request) {
+		if p == utils.BearerProtocol {
+			header = http.Header{"Sec-Websocket-Protocol": {utils.BearerProtocol}}
+			break
+		}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, header)
+	if err != nil {
+		log.Errorf("[getStatusWS] Upgrade: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	events := subscribe()
+	defer unsubscribe(events)
+
+	// Reader detects the client going away, incoming messages are ignored
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
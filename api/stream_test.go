@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamTokenInURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	withConfig(t, map[string]interface{}{"authentication.enable": true})
+	withServers(t, Config{})
+
+	srv := httptest.NewServer(newTestRouter(issuer.verifier()))
+	defer srv.Close()
+	token := issuer.token(t, realmRoles("strdb_operator"))
+
+	get := func(url string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %s", url, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(srv.URL + "/status/stream"); code != http.StatusUnauthorized {
+		t.Errorf("stream without token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get(srv.URL + "/status/stream?access_token=bad"); code != http.StatusUnauthorized {
+		t.Errorf("stream with bad token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := get(srv.URL + "/status/stream?access_token=" + token); code != http.StatusOK {
+		t.Errorf("stream with token = %d, want %d", code, http.StatusOK)
+	}
+	// Other routes still need the header
+	if code := get(srv.URL + "/status?access_token=" + token); code != http.StatusUnauthorized {
+		t.Errorf("status with token in URL = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/status/ws"
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+
+	if _, resp, err := dialer.Dial(wsURL, nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ws without token: err %v, want %d", err, http.StatusUnauthorized)
+	}
+
+	dialer.Subprotocols = []string{"bearer", token}
+	conn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("ws with subprotocol token: %s", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || conn.Subprotocol() != "bearer" {
+		t.Errorf("ws with subprotocol token = %d, protocol %q", resp.StatusCode, conn.Subprotocol())
+	}
+
+	dialer.Subprotocols = nil
+	conn, _, err = dialer.Dial(wsURL+"?access_token="+token, nil)
+	if err != nil {
+		t.Fatalf("ws with token in URL: %s", err)
+	}
+	conn.Close()
+}
@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,14 +17,70 @@ import (
 )
 
 type Server struct {
-	Name       string `json:"name"`
-	DNS        string `json:"dns"`
-	Sessions   int    `json:"sessions"`
-	Enable     bool   `json:"enable"`
-	Online     bool   `json:"online"`
-	Region     string `json:"region"`      // Region restriction, e.g., "RU" for Russia-only servers
-	MissedPing int    `json:"-"`           // Not serialized - counts missed admin responses
-	LastSeen   int64  `json:"last_seen"`   // Unix timestamp of last successful admin response
+	Name        string     `json:"name"`
+	DNS         string     `json:"dns"`
+	Sessions    int        `json:"sessions"`
+	Pending     int        `json:"pending"`                // Assignments not yet reported in Sessions
+	MaxSessions int        `json:"max_sessions,omitempty"` // Capacity limit, 0 means unlimited
+	Weight      float64    `json:"weight,omitempty"`       // Relative server power, 0 means 1
+	Enable      bool       `json:"enable"`
+	Online      bool       `json:"online"`
+	Draining    bool       `json:"draining"`               // No new assignments, waiting for sessions to end
+	Drained     bool       `json:"drained"`                // Draining finished, safe to restart
+	Region      Regions    `json:"region"`                 // Region restriction, e.g., "RU" for Russia-only servers or ["LT", "LV", "EE"]
+	MissedPing  int        `json:"-"`                      // Not serialized - counts missed admin responses
+	LastSeen    int64      `json:"last_seen"`              // Unix timestamp of last successful admin response
+	Health      string     `json:"health,omitempty"`       // Admin API health: "ok" or "admin_error"
+	AdminError  string     `json:"admin_error,omitempty"`  // Last Janus admin error
+	AdminRTT    float64    `json:"admin_rtt_ms,omitempty"` // Last admin request round-trip time
+	OnlineSince int64      `json:"online_since,omitempty"` // Unix timestamp the server came online, uptime as seen by strdb
+	Janus       *JanusInfo `json:"janus,omitempty"`        // Optional data from info, get_status and list_handles
+}
+
+// Accepting reports whether the server can be given new clients, capacity aside.
+// After a state restore a server is not given clients before its first
+// list_sessions reply, its sessions are unknown until then.
+func (s Server) Accepting() bool {
+	return s.Online && s.Enable && !s.Draining && (s.LastSeen > 0 || !stateRestored.Load())
+}
+
+// ExpectedSessions returns reported sessions plus assignments not reported yet
+func (s Server) ExpectedSessions() int {
+	return s.Sessions + s.Pending
+}
+
+// Full reports whether the server has reached its capacity limit
+func (s Server) Full() bool {
+	return s.MaxSessions > 0 && s.ExpectedSessions() >= s.MaxSessions
+}
+
+// defaultNominalCapacity is the capacity of a server without max_sessions and weight
+const defaultNominalCapacity = 1000
+
+// Capacity returns the sessions the server is sized for: max_sessions when set,
+// otherwise weight times routing.nominal_capacity. Weight is ignored for
+// servers with max_sessions, their capacity already says how strong they are.
+func (s Server) Capacity() float64 {
+	if s.MaxSessions > 0 {
+		return float64(s.MaxSessions)
+	}
+
+	nominal := viper.GetFloat64("routing.nominal_capacity")
+	if nominal <= 0 {
+		nominal = defaultNominalCapacity
+	}
+	weight := s.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return nominal * weight
+}
+
+// Load returns the server utilization used to rank servers: expected sessions
+// (pending assignments included) divided by Capacity. Capped and uncapped
+// servers are on the same scale, so they can share one pool.
+func (s Server) Load() float64 {
+	return float64(s.ExpectedSessions()) / s.Capacity()
 }
 
 type Config map[string]Server
@@ -34,19 +91,42 @@ var (
 	rnd   *rand.Rand
 )
 
+var (
+	ErrNoAvailableServers = errors.New("getBestServerForCountry: no available servers")
+	ErrAllServersFull     = errors.New("getBestServerForCountry: all servers are full")
+)
+
 func getJson() (*Config, error) {
 	req, err := http.NewRequest("GET", viper.GetString("server.cfg_url"), nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
+
+	// Conditional GET, the source answers 304 when nothing changed
+	cfgMutex.Lock()
+	if cfgETag != "" {
+		req.Header.Set("If-None-Match", cfgETag)
+	}
+	if cfgLastModified != "" {
+		req.Header.Set("If-Modified-Since", cfgLastModified)
+	}
+	cfgMutex.Unlock()
+
+	client := &http.Client{Timeout: 30 * time.Second}
 	res, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getJson: unexpected status %s", res.Status)
+	}
+
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
@@ -58,6 +138,16 @@ func getJson() (*Config, error) {
 		return nil, err
 	}
 
+	// Bad remote config never goes live, the last good one stays
+	if err := ValidateConfig(conf); err != nil {
+		return nil, err
+	}
+
+	cfgMutex.Lock()
+	cfgETag = res.Header.Get("ETag")
+	cfgLastModified = res.Header.Get("Last-Modified")
+	cfgMutex.Unlock()
+
 	return &conf, nil
 }
 
@@ -67,6 +157,7 @@ func InitConf() error {
 
 	strdb, err := getJson()
 	if err != nil {
+		log.Errorf("Get remote conf error: %s", err)
 		strdb, err = getConf()
 	}
 
@@ -77,11 +168,17 @@ func InitConf() error {
 	mutex.Lock()
 	StrDB = *strdb
 	mutex.Unlock()
+	confLoaded.Store(true)
 	return err
 }
 
 func getConf() (*Config, error) {
-	file, err := os.Open("conf.json")
+	return LoadConfigFile("conf.json")
+}
+
+// LoadConfigFile reads and validates server list from a file
+func LoadConfigFile(path string) (*Config, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
@@ -93,118 +190,153 @@ func getConf() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	if err := ValidateConfig(Config); err != nil {
+		return nil, err
+	}
 	return &Config, nil
 }
 
-func getBestServer() (string, error) {
-	return getBestServerForCountry("")
-}
+func getBestServerForCountry(countryCode string, user *User) (*Assignment, error) {
+	// Write lock: selection and pending accounting must be atomic
+	mutex.Lock()
+	defer mutex.Unlock()
 
-func getBestServerForCountry(countryCode string) (string, error) {
-	mutex.RLock()
-	defer mutex.RUnlock()
+	// Client country codes are compared in upper case like Server.Region
+	countryCode = strings.ToUpper(countryCode)
 
-	var available []Server
-	var regionalServers []Server
-	var globalServers []Server
+	// Walk the pool chain: country's own pool first, then its fallback policy
+	groups := regionGroups()
+	chain := poolChain(countryCode, groups)
+
+	key := userKey(user)
+	room := roomKey(user)
 
-	// Filter servers based on country code and region restrictions
-	for _, server := range StrDB {
-		if !server.Online || !server.Enable {
-			continue
+	var available []Server
+	var poolType string
+	var full int
+
+	for _, pool := range chain {
+		servers, poolFull := poolServers(pool, countryCode, groups)
+		full += poolFull
+		if len(servers) > 0 {
+			available = servers
+			poolType = pool
+			break
 		}
 
-		if server.Region == "" {
-			// Global server
-			globalServers = append(globalServers, server)
-		} else if server.Region == countryCode {
-			// Regional server matching client's country
-			regionalServers = append(regionalServers, server)
+		log.WithFields(log.Fields{
+			"country_code": countryCode,
+			"pool_type":    pool,
+			"full_servers": poolFull,
+			"pool_chain":   chain,
+		}).Warn("Server pool exhausted, trying next pool")
+	}
+
+	if len(available) == 0 {
+		err := ErrNoAvailableServers
+		reason := "no_available_servers"
+		if full > 0 {
+			err = ErrAllServersFull
+			reason = "all_servers_full"
 		}
-		// Otherwise skip - this server is for a different region
+		selectionFailuresTotal.WithLabelValues(countryLabel(countryCode), reason).Inc()
+		log.WithFields(log.Fields{
+			"country_code": countryCode,
+			"pool_chain":   chain,
+			"full_servers": full,
+		}).Error(err)
+		return nil, err
 	}
 
-	// Logic: If regional servers exist for this country, use ONLY them
-	// Otherwise, use global servers
-	var poolType string
-	if len(regionalServers) > 0 {
-		// Country has dedicated regional servers - use only those
-		available = regionalServers
-		poolType = "regional"
+	// Returning user gets the previous server back while it can take them
+	if a, ok := stickyServer(key, poolType, available); ok {
+		commitAssignment(a, key, room, countryCode, "sticky")
 		log.WithFields(log.Fields{
 			"country_code":     countryCode,
-			"regional_servers": len(regionalServers),
-			"global_servers":   len(globalServers),
-			"pool_type":        poolType,
-		}).Info("Using regional server pool (global servers excluded)")
-	} else {
-		// No regional servers for this country - use global servers
-		available = globalServers
-		poolType = "global"
+			"user_key":         key,
+			"pool_type":        a.Pool,
+			"selected_server":  a.Server,
+			"selection_reason": "sticky",
+		}).Info("Server selected for client")
+		return a, nil
+	}
+
+	// Participants of the same room go to the server already hosting it
+	if a, ok := roomServer(room, poolType, available); ok {
+		commitAssignment(a, key, room, countryCode, "room")
 		log.WithFields(log.Fields{
 			"country_code":     countryCode,
-			"regional_servers": 0,
-			"global_servers":   len(globalServers),
-			"pool_type":        poolType,
-		}).Info("Using global server pool (no regional servers for this country)")
+			"room":             room,
+			"pool_type":        a.Pool,
+			"selected_server":  a.Server,
+			"selection_reason": "room affinity",
+		}).Info("Server selected for client")
+		return a, nil
 	}
 
-	if len(available) == 0 {
-		err := errors.New("getBestServerForCountry: no available servers")
+	if poolType == chain[0] {
 		log.WithFields(log.Fields{
 			"country_code": countryCode,
-		}).Error(err)
-		return "", err
+			"servers":      len(available),
+			"pool_type":    poolType,
+		}).Info("Using preferred server pool")
+	} else {
+		log.WithFields(log.Fields{
+			"country_code": countryCode,
+			"servers":      len(available),
+			"pool_type":    poolType,
+			"pool_chain":   chain,
+		}).Warn("Using fallback server pool")
 	}
 
 	// Build list of available server names for logging
+	sortServers(available)
 	var availableNames []string
 	for _, s := range available {
-		availableNames = append(availableNames, fmt.Sprintf("%s(%d)", s.Name, s.Sessions))
+		availableNames = append(availableNames, serverLogName(s))
 	}
 
-	// Find server with minimum sessions
-	minSessions := available[0].Sessions
-	minSessionsServers := []Server{available[0]}
-
-	for _, server := range available[1:] {
-		if server.Sessions < minSessions {
-			minSessions = server.Sessions
-			minSessionsServers = []Server{server}
-		} else if server.Sessions == minSessions {
-			minSessionsServers = append(minSessionsServers, server)
-		}
-	}
-
-	// Build list of candidates with minimum sessions
-	var candidateNames []string
-	for _, s := range minSessionsServers {
-		candidateNames = append(candidateNames, s.Name)
-	}
-
-	// If we have multiple servers with the same minimum sessions, choose randomly
-	randomIndex := rnd.Intn(len(minSessionsServers))
-	selectedServer := minSessionsServers[randomIndex]
-
-	selectionReason := "minimum sessions"
-	if len(minSessionsServers) > 1 {
-		selectionReason = fmt.Sprintf("random from %d servers with minimum sessions", len(minSessionsServers))
-	}
+	// Pick a server with the strategy configured for the client's country,
+	// whichever pool of its chain serves it
+	selector, strategy := getSelector(regionPolicy(countryCode).Strategy)
+	selectedServer, selectionReason := selector.Select(candidateSet(countryCode, poolType), available, user)
 
 	log.WithFields(log.Fields{
 		"country_code":      countryCode,
 		"pool_type":         poolType,
 		"available_servers": availableNames,
-		"min_sessions":      minSessions,
-		"candidates":        candidateNames,
+		"strategy":          strategy,
 		"selected_server":   selectedServer.Name,
 		"server_dns":        selectedServer.DNS,
 		"server_sessions":   selectedServer.Sessions,
+		"server_pending":    selectedServer.Pending,
+		"server_capacity":   selectedServer.MaxSessions,
 		"server_region":     selectedServer.Region,
 		"selection_reason":  selectionReason,
 	}).Info("Server selected for client")
 
-	return selectedServer.Name, nil
+	a := &Assignment{Server: selectedServer.Name, Pool: poolType}
+	commitAssignment(a, key, room, countryCode, strategy)
+
+	return a, nil
+}
+
+// commitAssignment records the assignment for stickiness, room placement,
+// pending accounting and metrics. Must be called with mutex held.
+func commitAssignment(a *Assignment, key string, room string, countryCode string, reason string) {
+	rememberAssignment(key, a)
+	placeInRoom(room, a.Server)
+	addPending(a.Server)
+	assignmentsTotal.WithLabelValues(a.Server, countryLabel(countryCode), a.Pool, reason).Inc()
+}
+
+// serverLogName formats server name with its sessions and capacity for logs
+func serverLogName(s Server) string {
+	if s.MaxSessions > 0 {
+		return fmt.Sprintf("%s(%d/%d)", s.Name, s.ExpectedSessions(), s.MaxSessions)
+	}
+	return fmt.Sprintf("%s(%d)", s.Name, s.ExpectedSessions())
 }
 
 func SetOnline(name string, status bool) {
@@ -212,7 +344,8 @@ func SetOnline(name string, status bool) {
 	defer mutex.Unlock()
 
 	if server, ok := StrDB[name]; ok {
-		if server.Online != status {
+		changed := server.Online != status
+		if changed {
 			log.WithFields(log.Fields{
 				"server":     name,
 				"old_status": server.Online,
@@ -222,8 +355,30 @@ func SetOnline(name string, status bool) {
 		server.Online = status
 		if status {
 			server.MissedPing = 0
+			if changed || server.OnlineSince == 0 {
+				server.OnlineSince = time.Now().Unix()
+			}
+		} else {
+			server.OnlineSince = 0
 		}
 		StrDB[name] = server
+
+		if !status {
+			clearAssignments(name)
+			clearRoomServer(name)
+			clearPending(name)
+			forgetJanusInfo(name)
+		}
+
+		// Janus went down by itself, a draining server is done
+		if !status && server.Draining && !server.Drained {
+			markDrained(name)
+		}
+
+		if changed {
+			emitServer(name)
+			publishServerEvent(name, status, "status")
+		}
 	}
 }
 
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestServerCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		nominal  interface{}
+		server   Server
+		capacity float64
+		load     float64
+		full     bool
+	}{
+		{"max_sessions", nil, Server{Sessions: 250, MaxSessions: 500}, 500, 0.5, false},
+		{"weight ignored with max_sessions", nil, Server{Sessions: 250, MaxSessions: 500, Weight: 4}, 500, 0.5, false},
+		{"uncapped", nil, Server{Sessions: 100}, 1000, 0.1, false},
+		{"uncapped weight", nil, Server{Sessions: 100, Weight: 2}, 2000, 0.05, false},
+		{"nominal capacity", 200, Server{Sessions: 100}, 200, 0.5, false},
+		{"nominal capacity and weight", 200, Server{Sessions: 100, Weight: 0.5}, 100, 1, false},
+		{"uncapped never full", nil, Server{Sessions: 5000}, 1000, 5, false},
+		{"pending counts", nil, Server{Sessions: 400, Pending: 100, MaxSessions: 1000}, 1000, 0.5, false},
+		{"full at max_sessions", nil, Server{Sessions: 500, MaxSessions: 500}, 500, 1, true},
+		{"full with pending", nil, Server{Sessions: 499, Pending: 1, MaxSessions: 500}, 500, 1, true},
+		{"one below max_sessions", nil, Server{Sessions: 498, Pending: 1, MaxSessions: 500}, 500, 0.998, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := map[string]interface{}{}
+			if tt.nominal != nil {
+				settings["routing.nominal_capacity"] = tt.nominal
+			}
+			withConfig(t, settings)
+
+			if got := tt.server.Capacity(); got != tt.capacity {
+				t.Errorf("Capacity() = %v, want %v", got, tt.capacity)
+			}
+			if got := tt.server.Load(); got != tt.load {
+				t.Errorf("Load() = %v, want %v", got, tt.load)
+			}
+			if got := tt.server.Full(); got != tt.full {
+				t.Errorf("Full() = %v, want %v", got, tt.full)
+			}
+		})
+	}
+}
+
+func TestLeastLoadSelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers Config
+		want    string
+	}{
+		{
+			name: "lowest load, not lowest sessions",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 150, MaxSessions: 200, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 500, MaxSessions: 1600, Enable: true, Online: true},
+			},
+			want: "str2",
+		},
+		{
+			name: "capped and uncapped on one scale",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 300, MaxSessions: 1000, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 400, Enable: true, Online: true},
+			},
+			want: "str1",
+		},
+		{
+			name: "uncapped weight",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 300, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 500, Weight: 2, Enable: true, Online: true},
+			},
+			want: "str2",
+		},
+		{
+			name: "full server skipped",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 10, MaxSessions: 10, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 900, MaxSessions: 1000, Enable: true, Online: true},
+			},
+			want: "str2",
+		},
+		{
+			name: "server full with pending skipped",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 9, Pending: 1, MaxSessions: 10, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 900, MaxSessions: 1000, Enable: true, Online: true},
+			},
+			want: "str2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, map[string]interface{}{})
+			withServers(t, tt.servers)
+
+			a, err := getBestServerForCountry("", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Server != tt.want {
+				t.Errorf("selected %s, want %s", a.Server, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapacitySplit(t *testing.T) {
+	withConfig(t, map[string]interface{}{"routing.pending_timeout": "1m"})
+	withServers(t, Config{
+		"str1": {Name: "str1", MaxSessions: 500, Enable: true, Online: true},
+		"str2": {Name: "str2", MaxSessions: 1500, Enable: true, Online: true},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 400; i++ {
+		a, err := getBestServerForCountry("", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[a.Server]++
+	}
+
+	// Ties are broken randomly, so the split may be off by one
+	if d := counts["str2"] - 3*counts["str1"]; d < -4 || d > 4 {
+		t.Errorf("split str1:str2 = %d:%d, want 1:3", counts["str1"], counts["str2"])
+	}
+}
+
+func TestSelectionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers Config
+		err     error
+		status  int
+	}{
+		{
+			name: "all full",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 10, MaxSessions: 10, Enable: true, Online: true},
+				"str2": {Name: "str2", Sessions: 5, Pending: 5, MaxSessions: 10, Enable: true, Online: true},
+			},
+			err:    ErrAllServersFull,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "full and offline",
+			servers: Config{
+				"str1": {Name: "str1", Sessions: 10, MaxSessions: 10, Enable: true, Online: true},
+				"str2": {Name: "str2", Enable: true},
+			},
+			err:    ErrAllServersFull,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "none online",
+			servers: Config{
+				"str1": {Name: "str1", Enable: true},
+				"str2": {Name: "str2", Online: true},
+			},
+			err:    ErrNoAvailableServers,
+			status: http.StatusNotFound,
+		},
+		{
+			name:    "no servers",
+			servers: Config{},
+			err:     ErrNoAvailableServers,
+			status:  http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, map[string]interface{}{})
+			withServers(t, tt.servers)
+
+			_, err := getBestServerForCountry("", nil)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if got := selectionErrorStatus(err); got != tt.status {
+				t.Errorf("selectionErrorStatus() = %d, want %d", got, tt.status)
+			}
+		})
+	}
+}
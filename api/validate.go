@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ConfigErrors lists all problems found in a config
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e, "; "))
+}
+
+// ValidateConfig checks the server list before it goes live:
+// map key matches server name, names and DNS are unique, regions are
+// ISO country codes or known groups, capacity values are sane.
+// Fallback pools of the routing config are checked as well.
+func ValidateConfig(conf Config) error {
+	var errs ConfigErrors
+	groups := regionGroups()
+	dns := map[string]string{}
+
+	errs = append(errs, validateFallback(groups)...)
+
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, key := range names {
+		server := conf[key]
+		for _, problem := range validateServer(server, groups) {
+			errs = append(errs, fmt.Sprintf("%s: %s", key, problem))
+		}
+
+		if server.Name != key {
+			errs = append(errs, fmt.Sprintf("%s: key does not match name %q", key, server.Name))
+		}
+
+		if server.DNS != "" {
+			host := strings.ToLower(server.DNS)
+			if other, ok := dns[host]; ok {
+				errs = append(errs, fmt.Sprintf("%s: dns %s already used by %s", key, server.DNS, other))
+			} else {
+				dns[host] = key
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateFallback checks routing.fallback and routing.regions.<code>.fallback,
+// a typo would otherwise quietly leave a country without its fallback pool
+func validateFallback(groups RegionGroups) []string {
+	keys := []string{"routing.fallback"}
+	var codes []string
+	for code := range viper.GetStringMap("routing.regions") {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		keys = append(keys, "routing.regions."+code+".fallback")
+	}
+
+	var problems []string
+	for _, key := range keys {
+		for _, pool := range viper.GetStringSlice(key) {
+			if !strings.EqualFold(pool, PoolGlobal) && !validRegion(strings.ToUpper(pool), groups) {
+				problems = append(problems, fmt.Sprintf("%s: unknown pool %q, expected global, ISO country code, group or continent", key, pool))
+			}
+		}
+	}
+	return problems
+}
+
+func validateServer(server Server, groups RegionGroups) []string {
+	var problems []string
+
+	if server.Name == "" {
+		problems = append(problems, "name is empty")
+	}
+	if server.DNS == "" {
+		problems = append(problems, "dns is empty")
+	}
+	if server.MaxSessions < 0 {
+		problems = append(problems, fmt.Sprintf("max_sessions %d is negative", server.MaxSessions))
+	}
+	if server.Weight < 0 {
+		problems = append(problems, fmt.Sprintf("weight %g is negative", server.Weight))
+	}
+	if server.Sessions < 0 {
+		problems = append(problems, fmt.Sprintf("sessions %d is negative", server.Sessions))
+	}
+
+	for _, region := range server.Region {
+		if !validRegion(region, groups) {
+			problems = append(problems, fmt.Sprintf("unknown region %q, expected ISO country code, group or continent", region))
+		}
+	}
+
+	return problems
+}
+
+// validRegion reports whether the region entry is a country code, a configured group or a continent
+func validRegion(region string, groups RegionGroups) bool {
+	if _, ok := continents[region]; ok {
+		return true
+	}
+	if _, ok := groups[region]; ok {
+		return true
+	}
+
+	prefix := strings.ToUpper(continentPrefix)
+	if strings.HasPrefix(region, prefix) {
+		code := strings.TrimPrefix(region, prefix)
+		for _, continent := range continents {
+			if continent == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	valid := func(name string, region ...string) Server {
+		return Server{Name: name, DNS: name + ".example.com", Region: Regions(region)}
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		conf     Config
+		problems []string
+	}{
+		{
+			name: "valid",
+			settings: map[string]interface{}{
+				"routing.groups":   map[string]interface{}{"baltics": []string{"LT", "LV", "EE"}},
+				"routing.fallback": []string{"Global"},
+				"routing.regions":  map[string]interface{}{"ru": map[string]interface{}{"fallback": []string{"baltics", "continent:eu", "DE", "GLOBAL"}}},
+			},
+			conf: Config{
+				"str1": valid("str1"),
+				"str2": valid("str2", "RU"),
+				"str3": valid("str3", "LT", "LV"),
+				"str4": valid("str4", "BALTICS"),
+				"str5": valid("str5", "CONTINENT:SA"),
+			},
+		},
+		{
+			name:     "unknown country code",
+			conf:     Config{"str1": valid("str1", "XX")},
+			problems: []string{`str1: unknown region "XX"`},
+		},
+		{
+			name:     "group not configured",
+			conf:     Config{"str1": valid("str1", "BALTICS")},
+			problems: []string{`str1: unknown region "BALTICS"`},
+		},
+		{
+			name:     "unknown continent",
+			conf:     Config{"str1": valid("str1", "CONTINENT:XX")},
+			problems: []string{`str1: unknown region "CONTINENT:XX"`},
+		},
+		{
+			name:     "plain continent code is not a group",
+			conf:     Config{"str1": valid("str1", "EU")},
+			problems: []string{`str1: unknown region "EU"`},
+		},
+		{
+			name: "duplicate dns",
+			conf: Config{
+				"str1": {Name: "str1", DNS: "str.example.com"},
+				"str2": {Name: "str2", DNS: "STR.example.com"},
+			},
+			problems: []string{"str2: dns STR.example.com already used by str1"},
+		},
+		{
+			name:     "key does not match name",
+			conf:     Config{"str1": valid("str2")},
+			problems: []string{`str1: key does not match name "str2"`},
+		},
+		{
+			name:     "empty name and dns",
+			conf:     Config{"str1": {}},
+			problems: []string{"str1: name is empty", "str1: dns is empty", `str1: key does not match name ""`},
+		},
+		{
+			name:     "negative capacity",
+			conf:     Config{"str1": {Name: "str1", DNS: "str1.example.com", MaxSessions: -1, Weight: -0.5, Sessions: -2}},
+			problems: []string{"max_sessions -1 is negative", "weight -0.5 is negative", "sessions -2 is negative"},
+		},
+		{
+			name:     "unknown fallback pool",
+			settings: map[string]interface{}{"routing.fallback": []string{"globl"}},
+			conf:     Config{"str1": valid("str1")},
+			problems: []string{`routing.fallback: unknown pool "globl"`},
+		},
+		{
+			name:     "unknown country fallback pool",
+			settings: map[string]interface{}{"routing.regions": map[string]interface{}{"ru": map[string]interface{}{"fallback": []string{"EU"}}}},
+			conf:     Config{"str1": valid("str1")},
+			problems: []string{`routing.regions.ru.fallback: unknown pool "EU"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, tt.settings)
+
+			err := ValidateConfig(tt.conf)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateConfig() = %v, want nil", err)
+				}
+				return
+			}
+
+			var errs ConfigErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("ValidateConfig() = %v, want ConfigErrors", err)
+			}
+			if len(errs) != len(tt.problems) {
+				t.Errorf("ValidateConfig() = %v, want %d problems", errs, len(tt.problems))
+			}
+			for _, p := range tt.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("ValidateConfig() = %v, want %q", err, p)
+				}
+			}
+		})
+	}
+}
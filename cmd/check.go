@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Bnei-Baruch/strdb/api"
+	"github.com/spf13/cobra"
+)
+
+var checkConfigCmd = &cobra.Command{
+	Use:   "check-config <file>",
+	Short: "Validate a server list file",
+	Args:  cobra.ExactArgs(1),
+	Run:   checkConfigFn,
+}
+
+func init() {
+	rootCmd.AddCommand(checkConfigCmd)
+}
+
+func checkConfigFn(cmd *cobra.Command, args []string) {
+	// Region groups live in the main config, it is optional here
+	readConfig(false)
+	if err := CheckConfig(args[0]); err != nil {
+		fmt.Printf("%s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// CheckConfig validates a server list file, region groups are taken from the loaded viper config
+func CheckConfig(path string) error {
+	conf, err := api.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: OK, %d servers\n", path, len(*conf))
+	return nil
+}
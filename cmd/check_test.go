@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "conf.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckConfig(t *testing.T) {
+	viper.Reset()
+	viper.Set("routing.groups", map[string]interface{}{"baltics": []string{"LT", "LV", "EE"}})
+	t.Cleanup(viper.Reset)
+
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"valid", `{"str1": {"name": "str1", "dns": "str1.example.com", "region": "baltics"}}`, ""},
+		{"legacy region string", `{"str1": {"name": "str1", "dns": "str1.example.com", "region": "ru"}}`, ""},
+		{"invalid", `{"str1": {"name": "str1", "dns": "str1.example.com", "region": "XX", "max_sessions": -5}}`, "unknown region"},
+		{"malformed", `{"str1": `, "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckConfig(writeConfig(t, tt.data))
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("CheckConfig() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("CheckConfig() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if err := CheckConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("CheckConfig() of a missing file succeeded")
+	}
+}
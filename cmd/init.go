@@ -12,6 +12,9 @@ import (
 	"github.com/spf13/viper"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func Init() {
@@ -48,12 +51,23 @@ func Init() {
 		oidcIDTokenVerifier = oidcProvider.Verifier(&oidc.Config{
 			SkipClientIDCheck: true,
 		})
+		api.TokenVerifier = oidcIDTokenVerifier
 	}
 
 	// Init Config
 	if err := api.InitConf(); err != nil {
 		log.Errorf("CONFIG Init error: %s", err)
 	}
+	if err := api.RestoreState(); err != nil {
+		log.Errorf("STATE Restore error: %s", err)
+	}
+	go api.StartStateSaver()
+	go api.StartConfReloader()
+
+	// Init GeoIP
+	if err := api.InitGeoIP(); err != nil {
+		log.Errorf("GeoIP Init error: %s", err)
+	}
 
 	// Setup mqtt
 	if err := api.InitMQTT(); err != nil {
@@ -63,12 +77,19 @@ func Init() {
 	// Setup http
 	gin.SetMode(viper.GetString("server.mode"))
 	router := gin.New()
+	// Gin trusts every proxy by default, so X-Forwarded-For would let clients pick
+	// their GeoIP country. Trust none unless configured.
+	if err := router.SetTrustedProxies(viper.GetStringSlice("server.trusted_proxies")); err != nil {
+		log.Errorf("Trusted proxies error: %s", err)
+	}
+	if viper.IsSet("server.remote_ip_headers") {
+		router.RemoteIPHeaders = viper.GetStringSlice("server.remote_ip_headers")
+	}
 	router.Use(
 		cors.New(corsConfig),
 		utils.MdbLoggerMiddleware(),
 		utils.EnvMiddleware(oidcIDTokenVerifier),
 		utils.ErrorHandlingMiddleware(),
-		utils.AuthenticationMiddleware(),
 		utils.RecoveryMiddleware())
 
 	api.SetupRoutes(router)
@@ -77,10 +98,35 @@ func Init() {
 		Addr:    viper.GetString("server.addr"),
 		Handler: router,
 	}
+	srv.RegisterOnShutdown(api.CloseStreams)
 
 	// service connections
-	log.Infoln("Running application")
-	if err := srv.ListenAndServe(); err != nil {
+	listenErr := make(chan error, 1)
+	go func() {
+		log.Infoln("Running application")
+		listenErr <- srv.ListenAndServe()
+	}()
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-listenErr:
 		log.Infof("Server listen: %s", err)
+	case sig := <-quit:
+		log.Infof("Received %s, shutting down", sig)
+	}
+
+	api.StopMQTT()
+	if err := api.SaveState(); err != nil {
+		log.Errorf("STATE Save error: %s", err)
+	}
+
+	viper.SetDefault("server.shutdown_timeout", 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("server.shutdown_timeout"))
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Errorf("Server shutdown: %s", err)
 	}
+	log.Info("Server stopped")
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var cfgFile string
+
+var rootCmd = &cobra.Command{
+	Use:   "strdb",
+	Short: "Janus streaming servers registry and client router",
+	// Without a subcommand strdb serves, as it always did
+	Run: serveFn,
+}
+
+func init() {
+	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.*)")
+}
+
+func initConfig() {
+	if cfgFile != "" {
+		viper.SetConfigFile(cfgFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+	}
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.AutomaticEnv()
+}
+
+// readConfig reads the main config, commands which can't work without it exit on error
+func readConfig(required bool) {
+	if err := viper.ReadInConfig(); err != nil && required {
+		fmt.Println("Could not read config, using: ", viper.ConfigFileUsed(), err.Error())
+		os.Exit(1)
+	}
+}
+
+func Exec() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run strdb server",
+	Run:   serveFn,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
+
+func serveFn(cmd *cobra.Command, args []string) {
+	readConfig(true)
+	Init()
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/Bnei-Baruch/strdb/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate <file>",
+	Short: "Simulate a burst of clients against a server list file",
+	Long: "Sends a burst of concurrent assignment requests through the regular selection\n" +
+		"path using the routing settings of the main config, and prints the distribution.",
+	Args: cobra.ExactArgs(1),
+	Run:  simulateFn,
+}
+
+var (
+	simRequests    int
+	simConcurrency int
+	simCountry     string
+	simVerbose     bool
+)
+
+func init() {
+	simulateCmd.Flags().IntVar(&simRequests, "requests", 1000, "number of simulated clients")
+	simulateCmd.Flags().IntVar(&simConcurrency, "concurrency", 100, "number of concurrent requests")
+	simulateCmd.Flags().StringVar(&simCountry, "country", "", "client country code")
+	simulateCmd.Flags().BoolVar(&simVerbose, "verbose", false, "print selection log")
+	rootCmd.AddCommand(simulateCmd)
+}
+
+func simulateFn(cmd *cobra.Command, args []string) {
+	readConfig(false)
+	if !simVerbose {
+		log.SetOutput(io.Discard)
+	}
+
+	conf, err := api.LoadConfigFile(args[0])
+	if err != nil {
+		fmt.Printf("%s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+
+	result := api.Simulate(*conf, simRequests, simConcurrency, simCountry)
+
+	fmt.Printf("requests: %d, country: %q\n", result.Requests, simCountry)
+	printCounts("servers", result.Servers, *conf)
+	printCounts("pools", result.Pools, nil)
+	printCounts("errors", result.Errors, nil)
+}
+
+func printCounts(title string, counts map[string]int, conf api.Config) {
+	if len(counts) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Printf("%s:\n", title)
+	for _, k := range keys {
+		if s, ok := conf[k]; ok && s.MaxSessions > 0 {
+			fmt.Printf("  %-20s %6d  (%d+%d/%d)\n", k, counts[k], s.Sessions, counts[k], s.MaxSessions)
+		} else {
+			fmt.Printf("  %-20s %6d\n", k, counts[k])
+		}
+	}
+}
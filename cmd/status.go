@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Bnei-Baruch/strdb/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show servers of a running strdb",
+	Run:   statusFn,
+}
+
+var (
+	statusURL   string
+	statusToken string
+)
+
+func init() {
+	statusCmd.Flags().StringVar(&statusURL, "url", "", "strdb base URL (default is http://localhost<server.addr>)")
+	statusCmd.Flags().StringVar(&statusToken, "token", "", "bearer token for the operator role")
+	rootCmd.AddCommand(statusCmd)
+}
+
+func statusFn(cmd *cobra.Command, args []string) {
+	readConfig(false)
+
+	url := statusURL
+	if url == "" {
+		addr := viper.GetString("server.addr")
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		url = "http://" + addr
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(url, "/")+"/status", nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if statusToken != "" {
+		req.Header.Set("Authorization", "Bearer "+statusToken)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		fmt.Printf("%s: %s %s\n", req.URL, res.Status, body)
+		os.Exit(1)
+	}
+
+	conf := api.Config{}
+	if err := json.Unmarshal(body, &conf); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	printServers(conf)
+}
+
+func printServers(conf api.Config) {
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDNS\tREGION\tSESSIONS\tPENDING\tMAX\tONLINE\tENABLE\tDRAINING\tLAST SEEN")
+	for _, name := range names {
+		s := conf[name]
+		lastSeen := "-"
+		if s.LastSeen > 0 {
+			lastSeen = time.Since(time.Unix(s.LastSeen, 0)).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%t\t%t\t%t\t%s\n",
+			s.Name, s.DNS, strings.Join(s.Region, ","), s.Sessions, s.Pending, s.MaxSessions,
+			s.Online, s.Enable, s.Draining, lastSeen)
+	}
+	w.Flush()
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Bnei-Baruch/strdb/version"
+	"github.com/spf13/cobra"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version number of strdb",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("strdb version %s\n", version.Version)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(versionCmd)
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/coreos/go-oidc"
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
 	"net/http"
 	"strings"
 	"time"
@@ -48,42 +47,86 @@ type User struct {
 	AccountID string    `boil:"account_id" json:"account_id" toml:"account_id" yaml:"account_id"`
 }
 
-func AuthenticationMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		if viper.GetBool("authentication.enable") {
-			tokenVerifier := c.MustGet("TOKEN_VERIFIER").(*oidc.IDTokenVerifier)
+// BearerProtocol is the WebSocket subprotocol announcing a token, the client
+// offers it followed by the token, e.g. new WebSocket(url, ["bearer", token])
+const BearerProtocol = "bearer"
 
-			header, err := parseToken(c)
-			if err != nil {
-				c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
-				return
+// StreamTokenMiddleware lets clients which cannot set the Authorization header,
+// like EventSource and browser WebSocket, pass the token in the access_token
+// query parameter or in the WebSocket subprotocols. Put it before RequireRole.
+func StreamTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := streamToken(c.Request); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
 			}
+		}
 
-			token, err := tokenVerifier.Verify(context.TODO(), header)
-			if err != nil {
-				c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
-				return
-			}
-			c.Set("ID_TOKEN", token)
+		c.Next()
+	}
+}
 
-			var claims IDTokenClaims
-			if err = token.Claims(&claims); err != nil {
-				c.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypePublic)
-				return
-			}
-			c.Set("ID_TOKEN_CLAIMS", claims)
+func streamToken(r *http.Request) string {
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		return token
+	}
 
-			user, err := getUser(&claims)
-			if err != nil {
-				c.AbortWithError(http.StatusInternalServerError, err).SetType(gin.ErrorTypePrivate)
-				return
-			}
-			c.Set("USER", user)
+	protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i < len(protocols)-1; i++ {
+		if strings.TrimSpace(protocols[i]) == BearerProtocol {
+			return strings.TrimSpace(protocols[i+1])
 		}
+	}
+	return ""
+}
 
-		c.Next()
+// authenticate verifies the bearer token and stores the token, its claims and the user in context.
+// Aborts the request with 401 and returns false when the token is missing or invalid.
+func authenticate(c *gin.Context) bool {
+	tokenVerifier := c.MustGet("TOKEN_VERIFIER").(*oidc.IDTokenVerifier)
+
+	header, err := parseToken(c)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, err).SetType(gin.ErrorTypePublic)
+		return false
+	}
+
+	token, claims, err := VerifyToken(tokenVerifier, header)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, err).SetType(gin.ErrorTypePublic)
+		return false
+	}
+	c.Set("ID_TOKEN", token)
+	c.Set("ID_TOKEN_CLAIMS", *claims)
+
+	user, err := getUser(claims)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err).SetType(gin.ErrorTypePrivate)
+		return false
+	}
+	c.Set("USER", user)
+
+	return true
+}
+
+// VerifyToken verifies the raw token and decodes its claims.
+// Used for tokens which do not come in the Authorization header, e.g. MQTT commands.
+func VerifyToken(tokenVerifier *oidc.IDTokenVerifier, raw string) (*oidc.IDToken, *IDTokenClaims, error) {
+	if tokenVerifier == nil {
+		return nil, nil, errors.New("token verifier is not initialized")
+	}
+
+	token, err := tokenVerifier.Verify(context.TODO(), raw)
+	if err != nil {
+		return nil, nil, err
 	}
+
+	var claims IDTokenClaims
+	if err = token.Claims(&claims); err != nil {
+		return nil, nil, err
+	}
+
+	return token, &claims, nil
 }
 
 func getUser(claims *IDTokenClaims) (*User, error) {
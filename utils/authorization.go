@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// RequireRole authenticates the request and allows it only if the token has
+// one of the roles, either as a Keycloak realm role or as a client role in
+// resource_access (of authentication.client_id, or of any client when not set).
+// Empty roles list allows any valid token.
+// Without authentication.enable everything is allowed.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !viper.GetBool("authentication.enable") {
+			c.Next()
+			return
+		}
+
+		if !authenticate(c) {
+			return
+		}
+
+		if len(roles) > 0 {
+			claims := c.MustGet("ID_TOKEN_CLAIMS").(IDTokenClaims)
+			if !HasAnyRole(&claims, viper.GetString("authentication.client_id"), roles...) {
+				c.AbortWithError(http.StatusForbidden, fmt.Errorf("missing role, one of %v required", roles)).SetType(gin.ErrorTypePublic)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAuthentication rejects requests with 403 while authentication.enable
+// is off, so routes that change state never run open by accident.
+// Setting insecureKey to true in config allows them without authentication.
+func RequireAuthentication(insecureKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !viper.GetBool("authentication.enable") && !viper.GetBool(insecureKey) {
+			c.AbortWithError(http.StatusForbidden, fmt.Errorf("authentication is disabled, set %s to allow this route without it", insecureKey)).SetType(gin.ErrorTypePublic)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasAnyRole reports whether the claims contain one of the roles in realm_access
+// or in resource_access of the client. Empty client means any client.
+func HasAnyRole(claims *IDTokenClaims, client string, roles ...string) bool {
+	for _, role := range roles {
+		if hasRole(claims.RealmAccess.Roles, role) {
+			return true
+		}
+		for name, access := range claims.ResourceAccess {
+			if (client == "" || name == client) && hasRole(access.Roles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}